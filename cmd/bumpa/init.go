@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/git"
+	"codeberg.org/mutker/bumpa/internal/llm"
+	"codeberg.org/mutker/bumpa/internal/logger"
+	"codeberg.org/mutker/bumpa/internal/secret"
+	"codeberg.org/mutker/bumpa/internal/setup"
+)
+
+// defaultBaseURLs are offered when the provider is changed in the wizard
+var defaultBaseURLs = map[string]string{
+	llm.ProviderOpenAICompatible: "http://localhost:11434/v1",
+	llm.ProviderOllama:           "http://localhost:11434/api",
+}
+
+// runInit asks for the settings of the repository and writes them to .bumpa.yaml. With
+// no_confirm every question takes its default answer.
+func runInit(ctx context.Context, cfg *config.Config, repo *git.Repository) error {
+	root, err := repo.Root()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(root, config.RepoConfigFiles[0])
+	for _, name := range config.RepoConfigFiles {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+			path = filepath.Join(root, name)
+			overwrite, err := confirm(cfg, name+" already exists, overwrite it? (y/N) ", false)
+			if err != nil {
+				return err
+			}
+			if !overwrite {
+				logger.Info().Str("file", path).Msg("Kept the existing configuration")
+				return nil
+			}
+			break
+		}
+	}
+
+	projects := setup.Detect(root)
+	for _, project := range projects {
+		logger.Info().
+			Str("kind", project.Kind).
+			Str("version", project.Version).
+			Int("files", len(project.Files)).
+			Msg("Project detected")
+	}
+	if len(projects) == 0 {
+		logger.Info().Msg("No known project found, add version.files by hand")
+	}
+
+	opts := &setup.Options{
+		Provider: cfg.LLM.Provider,
+		BaseURL:  cfg.LLM.BaseURL,
+		Model:    cfg.LLM.Model,
+		APIKey:   apiKeyReference(cfg),
+		Ignore:   setup.Ignore(projects),
+		Files:    setup.Files(projects),
+		Commit:   true,
+		Tag:      true,
+	}
+
+	if err := askLLM(ctx, cfg, opts); err != nil {
+		return err
+	}
+	if err := askGit(cfg, opts); err != nil {
+		return err
+	}
+
+	if err := setup.WriteConfig(path, opts); err != nil {
+		return err
+	}
+	logger.Info().Str("file", path).Msg("Configuration written")
+
+	return askHook(cfg, repo)
+}
+
+// askLLM asks for the provider, endpoint and model, offering the models the endpoint serves
+func askLLM(ctx context.Context, cfg *config.Config, opts *setup.Options) error {
+	provider, err := ask(cfg, "LLM provider ("+llm.ProviderOpenAICompatible+" or "+llm.ProviderOllama+")", opts.Provider)
+	if err != nil {
+		return err
+	}
+	if provider != opts.Provider && defaultBaseURLs[provider] != "" {
+		opts.BaseURL = defaultBaseURLs[provider]
+	}
+	opts.Provider = provider
+
+	if opts.BaseURL, err = ask(cfg, "LLM base URL", opts.BaseURL); err != nil {
+		return err
+	}
+
+	if cfg.Offline {
+		opts.Model, err = ask(cfg, "LLM model", opts.Model)
+		return err
+	}
+
+	probe := cfg.LLM
+	probe.Provider = opts.Provider
+	probe.BaseURL = opts.BaseURL
+	models, err := llm.ListModels(ctx, &probe)
+	if err != nil || len(models) == 0 {
+		logger.Warn().Err(err).Msg("Could not list models, enter the model name by hand")
+		opts.Model, err = ask(cfg, "LLM model", opts.Model)
+		return err
+	}
+
+	if !slices.Contains(models, opts.Model) {
+		opts.Model = models[0]
+	}
+	if !cfg.NoConfirm {
+		printModels(models)
+	}
+	answer, err := ask(cfg, "LLM model, by number or name", opts.Model)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(answer); err == nil && n >= 1 && n <= len(models) {
+		answer = models[n-1]
+	}
+	opts.Model = answer
+
+	return nil
+}
+
+// askGit asks for the ignored files, the version files and what a version bump does in git
+func askGit(cfg *config.Config, opts *setup.Options) error {
+	answer, err := ask(cfg, "Files to ignore, comma separated or none", strings.Join(opts.Ignore, ", "))
+	if err != nil {
+		return err
+	}
+	opts.Ignore = []string{}
+	if answer != "none" {
+		for _, pattern := range strings.Split(answer, ",") {
+			if pattern = strings.TrimSpace(pattern); pattern != "" {
+				opts.Ignore = append(opts.Ignore, pattern)
+			}
+		}
+	}
+
+	if len(opts.Files) > 0 {
+		if !cfg.NoConfirm {
+			printFiles(opts.Files)
+		}
+		update, err := confirm(cfg, "Update these files on version bumps? (Y/n) ", true)
+		if err != nil {
+			return err
+		}
+		if !update {
+			opts.Files = nil
+		}
+	}
+
+	if opts.Commit, err = confirm(cfg, "Commit version bumps? (Y/n) ", opts.Commit); err != nil {
+		return err
+	}
+	opts.Tag, err = confirm(cfg, "Tag version bumps? (Y/n) ", opts.Tag)
+	return err
+}
+
+// askHook offers to install the prepare-commit-msg hook, which is not done by default
+func askHook(cfg *config.Config, repo *git.Repository) error {
+	install, err := confirm(cfg, "Install the "+setup.HookName+" hook to generate commit messages? (y/N) ", false)
+	if err != nil || !install {
+		return err
+	}
+
+	gitDir, err := repo.GitDir()
+	if err != nil {
+		return err
+	}
+	if err := setup.InstallHook(gitDir); err != nil {
+		return err
+	}
+	logger.Info().Str("file", setup.HookPath(gitDir)).Msg("Hook installed")
+
+	return nil
+}
+
+// apiKeyReference returns the configured api_key if it refers to the key rather than being it
+func apiKeyReference(cfg *config.Config) string {
+	if key := cfg.LLM.APIKey.Reveal(); secret.IsReference(key) {
+		return key
+	}
+	return ""
+}
+
+// ask returns the answer to prompt, or fallback if it is empty or no_confirm is set
+func ask(cfg *config.Config, prompt, fallback string) (string, error) {
+	if cfg.NoConfirm {
+		return fallback, nil
+	}
+	return getUserInput(prompt, fallback)
+}
+
+// confirm asks a yes or no question, returning fallback if the answer is empty or no_confirm
+// is set
+func confirm(cfg *config.Config, prompt string, fallback bool) (bool, error) {
+	if cfg.NoConfirm {
+		return fallback, nil
+	}
+	response, err := getUserResponse(prompt)
+	if err != nil {
+		return false, err
+	}
+	if response == "" {
+		return fallback, nil
+	}
+	return response == "y" || response == "yes", nil
+}
+
+//nolint:forbidigo // Direct console interaction required
+func printModels(models []string) {
+	fmt.Println("Available models:")
+	for i, model := range models {
+		fmt.Printf("  %2d. %s\n", i+1, model)
+	}
+}
+
+//nolint:forbidigo // Direct console interaction required
+func printFiles(files []config.VersionFile) {
+	fmt.Println("Version files:")
+	for _, file := range files {
+		fmt.Printf("  %s: %s\n", file.Path, strings.Join(file.Replace, ", "))
+	}
+}
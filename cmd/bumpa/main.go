@@ -6,17 +6,27 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"time"
 
+	"codeberg.org/mutker/bumpa/internal/cache"
 	"codeberg.org/mutker/bumpa/internal/commit"
 	"codeberg.org/mutker/bumpa/internal/config"
 	"codeberg.org/mutker/bumpa/internal/errors"
 	"codeberg.org/mutker/bumpa/internal/git"
 	"codeberg.org/mutker/bumpa/internal/llm"
 	"codeberg.org/mutker/bumpa/internal/logger"
+	"codeberg.org/mutker/bumpa/internal/secret"
 	"codeberg.org/mutker/bumpa/internal/version"
 )
 
+const bytesPerMB = 1024 * 1024
+
+// stdin is shared by all prompts, so input buffered by one is not lost to the next
+var stdin = bufio.NewReader(os.Stdin)
+
 type CommitAction struct {
 	Command string
 	Message string
@@ -41,8 +51,30 @@ func main() {
 }
 
 func run() error {
+	// Flags override configuration, so they are parsed before anything is loaded
+	args, err := config.ParseArgs(os.Args[1:])
+	if errors.Is(err, errors.ErrHelpShown) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Validation reports a broken configuration rather than failing to load it, migration
+	// repairs it, and the schema describes configurations without reading one
+	if args.Command == "config" && len(args.Args) > 0 {
+		switch args.Args[0] {
+		case "validate":
+			return validateConfig(args)
+		case "migrate":
+			return migrateConfig(args)
+		case "schema":
+			return config.WriteSchema(os.Stdout)
+		}
+	}
+
 	// Initialize logging first with initial config
-	loggingConfig, err := config.LoadInitialLogging()
+	loggingConfig, err := config.LoadInitialLogging(args)
 	if err != nil {
 		return errors.Wrap(errors.CodeInitFailed, err)
 	}
@@ -62,7 +94,7 @@ func run() error {
 	}
 
 	// Load full configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(args)
 	if err != nil {
 		return errors.Wrap(errors.CodeConfigError, err)
 	}
@@ -71,25 +103,112 @@ func run() error {
 		Str("command", cfg.Command).
 		Msg("Configuration loaded")
 
+	// Config and completion commands need neither a repository nor a model
+	switch cfg.Command {
+	case "config":
+		return runConfig(cfg)
+	case "completion":
+		return runCompletion(cfg)
+	}
+
 	ctx := context.Background()
 
-	llmClient, err := initializeLLMClient(cfg)
+	repo, err := openGitRepository(cfg)
 	if err != nil {
 		return err
 	}
 
-	repo, err := openGitRepository(cfg)
-	if err != nil {
-		return err
+	// Init probes the model endpoint itself, it may not be configured yet
+	if cfg.Command == "init" {
+		return runInit(ctx, cfg, repo)
+	}
+
+	// The cache is managed on disk, without a model to talk to
+	if cfg.Command == "cache" {
+		return runCache(cfg, repo)
 	}
 
-	if err := executeCommand(ctx, cfg, llmClient, repo); err != nil {
+	// Offline commands use their deterministic fallbacks and never talk to a model, and neither
+	// do commit messages rendered from the template alone
+	var llmClient llm.Client
+	switch {
+	case cfg.Offline:
+		logger.Info().Msg("Running offline, no LLM requests will be made")
+	case cfg.Command == "commit" && cfg.Commit.Mode == config.CommitModeTemplate && !cfg.Commit.PostProcess:
+		logger.Debug().Msg("Rendering the commit message from the template, no LLM requests will be made")
+	default:
+		llmClient, err = initializeLLMClient(cfg, repo)
+		if err != nil {
+			return err
+		}
+	}
+
+	tracker := llm.NewUsageTracker(&cfg.LLM)
+	ctx = llm.WithUsageTracker(ctx, tracker)
+
+	err = executeCommand(ctx, cfg, llmClient, repo)
+
+	// Report usage even when the command failed, the requests were made either way
+	if cfg.Command == "commit" || cfg.Command == "version" {
+		reportUsage(cfg, tracker)
+	}
+
+	if err != nil {
 		return errors.Wrap(errors.CodeRuntimeError, err)
 	}
 
 	return nil
 }
 
+// reportUsage prints the token usage of the run and appends it to the usage ledger
+func reportUsage(cfg *config.Config, tracker *llm.UsageTracker) {
+	total := tracker.Total()
+	if total.Calls == 0 && total.CachedCalls == 0 {
+		return
+	}
+
+	if cfg.LLM.Usage.Summary {
+		printUsage(tracker)
+	}
+
+	if cfg.LLM.Usage.Ledger != "" {
+		if err := tracker.AppendLedger(cfg.LLM.Usage.Ledger, cfg.Command); err != nil {
+			logger.Warn().Err(err).Msg("Failed to append to usage ledger")
+		}
+	}
+}
+
+// printUsage renders the per-function usage table
+//
+//nolint:forbidigo // Direct console interaction required
+func printUsage(tracker *llm.UsageTracker) {
+	fmt.Println("\nLLM usage:")
+	fmt.Printf("  %-26s %6s %7s %10s %11s %9s", "function", "calls", "cached", "prompt", "completion", "latency")
+	if tracker.Priced() {
+		fmt.Printf(" %10s", "cost")
+	}
+	fmt.Println()
+
+	rows := append(tracker.Functions(), tracker.Total())
+	for _, usage := range rows {
+		name := usage.Function
+		if usage.Estimated {
+			name += "*"
+		}
+		fmt.Printf("  %-26s %6d %7d %10d %11d %9s",
+			name, usage.Calls, usage.CachedCalls, usage.PromptTokens, usage.CompletionTokens,
+			usage.Latency.Round(time.Millisecond))
+		if tracker.Priced() {
+			fmt.Printf(" %10.4f", usage.Cost)
+		}
+		fmt.Println()
+	}
+
+	if tracker.Total().Estimated {
+		fmt.Println("  * token counts estimated, the provider did not report usage")
+	}
+}
+
 func executeCommand(ctx context.Context, cfg *config.Config, llmClient llm.Client, repo *git.Repository) error {
 	switch cfg.Command {
 	case "commit":
@@ -111,29 +230,255 @@ func executeCommand(ctx context.Context, cfg *config.Config, llmClient llm.Clien
 	}
 }
 
-func initializeLLMClient(cfg *config.Config) (llm.Client, error) {
+func initializeLLMClient(cfg *config.Config, repo *git.Repository) (llm.Client, error) {
+	// Replayed fixtures stand in for the model entirely, nothing is sent or cached
+	if cfg.LLM.Replay != "" {
+		logger.Info().Str("dir", cfg.LLM.Replay).Msg("Replaying recorded LLM fixtures")
+		return llm.NewReplayClient(cfg.LLM.Replay), nil
+	}
+
 	llmClient, err := llm.New(&cfg.LLM)
 	if err != nil {
 		return nil, errors.Wrap(errors.CodeLLMError, err)
 	}
 
+	if cfg.LLM.Cache.Enabled {
+		store, err := openCache(cfg, repo)
+		if err != nil {
+			logger.Warn().Err(err).Msg("LLM cache unavailable, continuing without it")
+		} else {
+			llmClient = llm.NewCachedClient(llmClient, store, cfg.LLM.Model, cfg.LLM.Cache.Functions)
+		}
+	}
+
+	if cfg.LLM.Record != "" {
+		logger.Info().Str("dir", cfg.LLM.Record).Msg("Recording LLM fixtures")
+		llmClient = llm.NewRecordingClient(llmClient, cfg.LLM.Record)
+	}
+
 	return llmClient, nil
 }
 
+// openCache returns the LLM response cache stored under .git/bumpa/cache
+func openCache(cfg *config.Config, repo *git.Repository) (*cache.Store, error) {
+	gitDir, err := repo.GitDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return cache.New(
+		filepath.Join(gitDir, "bumpa", "cache"),
+		cfg.LLM.Cache.TTL,
+		cfg.LLM.Cache.MaxSizeMB*bytesPerMB,
+	), nil
+}
+
+func runCache(cfg *config.Config, repo *git.Repository) error {
+	store, err := openCache(cfg, repo)
+	if err != nil {
+		return err
+	}
+
+	action := "stats"
+	if len(cfg.Args) > 0 {
+		action = cfg.Args[0]
+	}
+
+	switch action {
+	case "stats":
+		stats, err := store.Stats()
+		if err != nil {
+			return err
+		}
+		event := logger.Info().
+			Str("dir", stats.Dir).
+			Int("entries", stats.Entries).
+			Int("expired", stats.Expired).
+			Int("size_bytes", int(stats.TotalBytes))
+		if stats.Entries > 0 {
+			event = event.
+				Time("oldest", stats.Oldest).
+				Time("newest", stats.Newest)
+		}
+		event.Msg("LLM cache statistics")
+		return nil
+	case "clear":
+		removed, err := store.Clear()
+		if err != nil {
+			return err
+		}
+		logger.Info().Int("removed", removed).Msg("LLM cache cleared")
+		return nil
+	default:
+		return errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			"unknown cache action: "+action+" (expected stats or clear)",
+		)
+	}
+}
+
+func runConfig(cfg *config.Config) error {
+	action := ""
+	if len(cfg.Args) > 0 {
+		action = cfg.Args[0]
+	}
+
+	switch action {
+	case "show-defaults":
+		if _, err := os.Stdout.Write(config.DefaultFunctionsYAML()); err != nil {
+			return errors.Wrap(errors.CodeIOError, err)
+		}
+		return nil
+	case "explain":
+		if len(cfg.Args) < 2 {
+			return errors.WrapWithContext(
+				errors.CodeInputError,
+				errors.ErrInvalidInput,
+				"config explain requires a key, e.g. llm.model",
+			)
+		}
+		return explainConfig(cfg, cfg.Args[1])
+	default:
+		return errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			"unknown config action: "+action+" (expected show-defaults, explain, validate, migrate or schema)",
+		)
+	}
+}
+
+func runCompletion(cfg *config.Config) error {
+	if len(cfg.Args) != 1 {
+		return errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			"completion requires a shell: "+strings.Join(config.Shells, ", "),
+		)
+	}
+	return config.WriteCompletion(os.Stdout, cfg.Args[0])
+}
+
+// validateConfig prints every problem of the configuration, failing if any is an error
+//
+//nolint:forbidigo // Direct console interaction required
+func validateConfig(args *config.Args) error {
+	problems, err := config.Validate(args)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for i := range problems {
+		fmt.Println(problems[i].String())
+		if problems[i].Severity == config.SeverityError {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextConfigProblems, failed),
+		)
+	}
+	fmt.Println("Configuration is valid")
+	return nil
+}
+
+// migrateConfig rewrites the repository configuration file, or the one given with --config,
+// to the current config_version and prints what changed
+//
+//nolint:forbidigo // Direct console interaction required
+func migrateConfig(args *config.Args) error {
+	path := config.MigrationPath(args)
+	changes, err := config.MigrateFile(path)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("%s is at config_version %d\n", path, config.CurrentConfigVersion)
+		return nil
+	}
+	for _, change := range changes {
+		fmt.Printf("%s: %s\n", path, change)
+	}
+	return nil
+}
+
+// explainConfig prints the value every layer sets for key, marking the one in effect
+//
+//nolint:forbidigo // Direct console interaction required
+func explainConfig(cfg *config.Config, key string) error {
+	explanations := cfg.Sources.Explain(key)
+	if len(explanations) == 0 {
+		return errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			"unknown configuration key: "+key,
+		)
+	}
+
+	for _, explanation := range explanations {
+		effective := explanation.Origins[len(explanation.Origins)-1]
+		fmt.Printf("%s = %s\n", explanation.Key, displayValue(explanation.Key, effective.Value))
+		for i, origin := range explanation.Origins {
+			marker := " "
+			if i == len(explanation.Origins)-1 {
+				marker = "*"
+			}
+			fmt.Printf("  %s %-8s %s", marker, origin.Layer, displayValue(explanation.Key, origin.Value))
+			if origin.Source != "" {
+				fmt.Printf("  (%s)", origin.Source)
+			}
+			fmt.Println()
+		}
+	}
+
+	return nil
+}
+
+// displayValue formats a configuration value for output, hiding secrets
+func displayValue(key string, value interface{}) string {
+	text := fmt.Sprintf("%v", value)
+	if strings.HasSuffix(key, "api_key") && text != "" {
+		// References name where the key is kept, commands may carry arguments best not shown
+		if scheme, _ := secret.Split(text); scheme != "" && scheme != secret.SchemeCommand {
+			return text
+		}
+		return "********"
+	}
+	if text == "" {
+		return `""`
+	}
+	return text
+}
+
 func openGitRepository(cfg *config.Config) (*git.Repository, error) {
 	repo, err := git.OpenRepository(".", cfg.Git)
 	if err != nil {
 		return nil, errors.Wrap(errors.CodeGitError, err)
 	}
 
+	// Status, diffs and version files use paths relative to the worktree root, so commands
+	// work the same from any subdirectory
+	root, err := repo.Root()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(root); err != nil {
+		return nil, errors.Wrap(errors.CodeIOError, err)
+	}
+
 	return repo, nil
 }
 
 //nolint:forbidigo // Direct console interaction required
 func getUserResponse(prompt string) (string, error) {
 	fmt.Print(prompt)
-	reader := bufio.NewReader(os.Stdin)
-	response, err := reader.ReadString('\n')
+	response, err := stdin.ReadString('\n')
 	if err != nil {
 		return "", errors.Wrap(errors.CodeInputError, err)
 	}
@@ -141,6 +486,25 @@ func getUserResponse(prompt string) (string, error) {
 	return strings.TrimSpace(strings.ToLower(response)), nil
 }
 
+// getUserInput asks for a value, returning fallback if the answer is empty
+//
+//nolint:forbidigo // Direct console interaction required
+func getUserInput(prompt, fallback string) (string, error) {
+	if fallback != "" {
+		prompt += " [" + fallback + "]"
+	}
+	fmt.Print(prompt + ": ")
+	response, err := stdin.ReadString('\n')
+	if err != nil {
+		return "", errors.Wrap(errors.CodeInputError, err)
+	}
+
+	if response = strings.TrimSpace(response); response == "" {
+		return fallback, nil
+	}
+	return response, nil
+}
+
 func editContent(content, prefix string) string {
 	editor := os.Getenv("EDITOR")
 	if editor == "" {
@@ -184,10 +548,13 @@ func runCommit(ctx context.Context, cfg *config.Config, llmClient llm.Client, re
 	if err != nil {
 		return errors.Wrap(errors.CodeGitError, err)
 	}
+	generator.SetPreview(printPreview)
 
 	for {
-		// Get current workflow state
-		state, err := generator.GetWorkflowState(ctx)
+		// Get current workflow state, letting Ctrl-C cancel an in-flight generation
+		genCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+		state, err := generator.GetWorkflowState(genCtx)
+		stop()
 		if err != nil {
 			if errors.Is(err, errors.ErrInvalidInput) {
 				logger.Info().Msg("No changes to commit")
@@ -236,6 +603,7 @@ func runCommit(ctx context.Context, cfg *config.Config, llmClient llm.Client, re
 			if err != nil {
 				return errors.Wrap(errors.CodeGitError, err)
 			}
+			generator.SetPreview(printPreview)
 
 		default: // quit
 			logger.Info().Msg("Commit aborted")
@@ -244,6 +612,17 @@ func runCommit(ctx context.Context, cfg *config.Config, llmClient llm.Client, re
 	}
 }
 
+// printPreview renders the commit message as it is streamed
+//
+//nolint:forbidigo // Direct console interaction required
+func printPreview(fragment string, done bool) {
+	if done {
+		fmt.Println()
+		return
+	}
+	fmt.Print(fragment)
+}
+
 // Helper function to build commit prompt
 func buildCommitPrompt(state *commit.WorkflowState) string {
 	var prompt strings.Builder
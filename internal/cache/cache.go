@@ -0,0 +1,306 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	dirPerms     = 0o700
+	filePerms    = 0o600
+	entrySuffix  = ".json"
+	keySeparator = "\x00"
+)
+
+// Store is a content-addressed on-disk cache of LLM responses
+type Store struct {
+	mu       sync.Mutex
+	dir      string
+	ttl      time.Duration
+	maxBytes int64
+}
+
+// Entry is a single cached response
+type Entry struct {
+	Key       string    `json:"key"`
+	Model     string    `json:"model"`
+	Function  string    `json:"function"`
+	Response  string    `json:"response"`
+	CreatedAt time.Time `json:"created_at"` //nolint:tagliatelle // Snake case like the config files
+}
+
+// Stats describes the current contents of the cache
+type Stats struct {
+	Dir        string
+	Entries    int
+	Expired    int
+	TotalBytes int64
+	Oldest     time.Time
+	Newest     time.Time
+}
+
+// New creates a Store in dir. A zero ttl keeps entries forever and a zero maxBytes disables
+// size-based eviction.
+func New(dir string, ttl time.Duration, maxBytes int64) *Store {
+	return &Store{
+		dir:      dir,
+		ttl:      ttl,
+		maxBytes: maxBytes,
+	}
+}
+
+// Key derives the cache key from the parts that determine a response
+func Key(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, keySeparator)))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the cached entry for key if it exists and has not expired
+func (s *Store) Get(key string) (*Entry, bool) {
+	data, err := os.ReadFile(s.path(key))
+	if err != nil {
+		return nil, false
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		logger.Warn().
+			Err(err).
+			Str("key", key).
+			Msg("Ignoring corrupt cache entry")
+		return nil, false
+	}
+
+	if s.isExpired(&entry) {
+		return nil, false
+	}
+
+	return &entry, true
+}
+
+// Put stores entry under its key and evicts old entries if the cache exceeds its limits
+func (s *Store) Put(entry *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, dirPerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextDirCreate, s.dir),
+		)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			"failed to encode cache entry",
+		)
+	}
+
+	// Write to a temporary file first so concurrent readers never see partial entries
+	tmp, err := os.CreateTemp(s.dir, entry.Key+".tmp*")
+	if err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileCreate, s.dir),
+		)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, tmp.Name()),
+		)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, tmp.Name()),
+		)
+	}
+	if err := os.Chmod(tmp.Name(), filePerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, tmp.Name()),
+		)
+	}
+	if err := os.Rename(tmp.Name(), s.path(entry.Key)); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, s.path(entry.Key)),
+		)
+	}
+
+	return s.prune()
+}
+
+// Delete removes the entry for key if it exists
+func (s *Store) Delete(key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !os.IsNotExist(err) {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileDelete, s.path(key)),
+		)
+	}
+	return nil
+}
+
+// Stats reports the number and size of cached entries
+func (s *Store) Stats() (*Stats, error) {
+	files, err := s.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{Dir: s.dir}
+	for _, file := range files {
+		stats.Entries++
+		stats.TotalBytes += file.size
+		if s.ttl > 0 && time.Since(file.modTime) > s.ttl {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || file.modTime.Before(stats.Oldest) {
+			stats.Oldest = file.modTime
+		}
+		if file.modTime.After(stats.Newest) {
+			stats.Newest = file.modTime
+		}
+	}
+
+	return stats, nil
+}
+
+// Clear removes all cached entries and returns how many were removed
+func (s *Store) Clear() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files, err := s.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return 0, errors.WrapWithContext(
+				errors.CodeIOError,
+				err,
+				errors.FormatContext(errors.ContextFileDelete, file.path),
+			)
+		}
+	}
+
+	return len(files), nil
+}
+
+// prune removes expired entries, then the oldest entries until the cache fits in maxBytes
+func (s *Store) prune() error {
+	files, err := s.entries()
+	if err != nil {
+		return err
+	}
+
+	// Oldest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	var total int64
+	for _, file := range files {
+		total += file.size
+	}
+
+	evicted := 0
+	for _, file := range files {
+		expired := s.ttl > 0 && time.Since(file.modTime) > s.ttl
+		oversized := s.maxBytes > 0 && total > s.maxBytes
+		if !expired && !oversized {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return errors.WrapWithContext(
+				errors.CodeIOError,
+				err,
+				errors.FormatContext(errors.ContextFileDelete, file.path),
+			)
+		}
+		total -= file.size
+		evicted++
+	}
+
+	if evicted > 0 {
+		logger.Debug().
+			Int("evicted", evicted).
+			Int("remaining_bytes", int(total)).
+			Msg("Pruned LLM cache")
+	}
+
+	return nil
+}
+
+type entryFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (s *Store) entries() ([]entryFile, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileRead, s.dir),
+		)
+	}
+
+	files := make([]entryFile, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), entrySuffix) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue // Removed concurrently
+		}
+		files = append(files, entryFile{
+			path:    filepath.Join(s.dir, dirEntry.Name()),
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	return files, nil
+}
+
+func (s *Store) isExpired(entry *Entry) bool {
+	return s.ttl > 0 && time.Since(entry.CreatedAt) > s.ttl
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, key+entrySuffix)
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"text/template"
 	"time"
 
 	"codeberg.org/mutker/bumpa/internal/config"
@@ -12,6 +14,7 @@ import (
 	"codeberg.org/mutker/bumpa/internal/git"
 	"codeberg.org/mutker/bumpa/internal/llm"
 	"codeberg.org/mutker/bumpa/internal/logger"
+	"codeberg.org/mutker/bumpa/internal/redact"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 )
@@ -26,6 +29,11 @@ const (
 	headerPartCount  = 2  // Number of parts in commit header split
 	lineNumberOffset = 3  // Offset for human-readable line numbers
 	colonWithSpace   = ": "
+
+	// Output fields of the commit functions
+	summaryField = "summary"
+	messageField = "message"
+	diffField    = "diff"
 )
 
 // Valid commit patterns
@@ -34,14 +42,14 @@ var commitPatterns = struct {
 	description string
 	header      string
 }{
-	// Type and scope must be lowercase
-	typeScope: fmt.Sprintf(`^(%s)(\(%s\))?$`, validTypes, validScope),
+	// Type and scope must be lowercase, a trailing ! marks a breaking change
+	typeScope: fmt.Sprintf(`^(%s)(\(%s\))?!?$`, validTypes, validScope),
 
 	// Description can be mixed case
 	description: `^[a-z]+[a-z0-9 -]*[a-z0-9]$`,
 
 	// Type and scope lowercase, description can start with capital
-	header: fmt.Sprintf(`^(%s)(\(%s\))?: [A-Z][-A-Za-z0-9 ]+[a-z0-9]$`, validTypes, validScope),
+	header: fmt.Sprintf(`^(%s)(\(%s\))?!?: [A-Z][-A-Za-z0-9 ]+[a-z0-9]$`, validTypes, validScope),
 }
 
 // WorkflowState represents the current state of commit generation
@@ -65,6 +73,12 @@ type Commit struct {
 	generatedMessage   string
 	manualMessage      string
 	messageGeneratedAt time.Time
+	preview            llm.StreamHandler
+	summarizer         *llm.Summarizer
+	changedFiles       []string // Every changed path, outputs mentioning other files are rejected
+	redactor           *redact.Redactor
+	template           *template.Template // commit.template, for template mode and post-processing
+	ticketPattern      *regexp.Regexp
 }
 
 // CommitValidationResult holds the validation state and any error message
@@ -80,10 +94,24 @@ func NewGenerator(cfg *config.Config, llmClient llm.Client, repo *git.Repository
 		return nil, err
 	}
 
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl, ticketPattern, err := parseTemplate(&cfg.Commit)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Commit{
-		cfg:  cfg,
-		llm:  llmClient,
-		repo: repo,
+		redactor:      redactor,
+		cfg:           cfg,
+		llm:           llmClient,
+		repo:          repo,
+		summarizer:    llm.NewSummarizer(llmClient, cfg),
+		template:      tmpl,
+		ticketPattern: ticketPattern,
 	}, nil
 }
 
@@ -152,6 +180,11 @@ func (g *Commit) GetFilesToUpdate() ([]string, error) {
 }
 
 func (g *Commit) Generate(ctx context.Context) (string, error) {
+	// Offline the template is the only way to get a message
+	if g.cfg.Offline || g.cfg.Commit.Mode == config.CommitModeTemplate {
+		return g.generateFromTemplate()
+	}
+
 	fileSummaries, err := g.getFileSummaries(ctx)
 	if err != nil {
 		if errors.Is(err, errors.ErrInvalidInput) {
@@ -178,6 +211,17 @@ func (g *Commit) Generate(ctx context.Context) (string, error) {
 		Interface("summaries", fileSummaries).
 		Msg("File change summaries")
 
+	ctx = llm.WithOutputCheck(ctx, llm.ReferenceCheck(g.changedFiles))
+
+	fileSummaries, err = g.summarizer.ReduceDirectories(ctx, fileSummaries)
+	if err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			"failed to reduce file summaries",
+		)
+	}
+
 	diffSummary := g.generateDiffSummary(fileSummaries)
 	commitMessage, err := g.getCommitMessage(ctx, diffSummary)
 	if err != nil {
@@ -260,7 +304,14 @@ func (g *Commit) generateDiffSummary(fileSummaries map[string]string) string {
 	var fileChanges []string
 	var otherChanges []string
 
-	for file, summary := range fileSummaries {
+	files := make([]string, 0, len(fileSummaries))
+	for file := range fileSummaries {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		summary := fileSummaries[file]
 		if strings.Contains(summary, "only formatting") ||
 			strings.Contains(summary, "minor changes") ||
 			strings.Contains(summary, "various fixes") {
@@ -288,53 +339,51 @@ func (g *Commit) generateDiffSummary(fileSummaries map[string]string) string {
 	return summaryBuilder.String()
 }
 
-func (g *Commit) getFileSummary(ctx context.Context, path string, status git.StatusCode) (string, error) {
+// fileChange is a changed file with the function input prepared for its summary
+type fileChange struct {
+	path  string
+	input map[string]interface{}
+}
+
+// prepareFileChange reads the diff of a file and builds the generate_file_summary input
+func (g *Commit) prepareFileChange(path string, status git.StatusCode) (fileChange, error) {
 	logger.Debug().
 		Str("path", path).
 		Str("status", git.GetFileStatus(status)).
 		Msg("Analyzing file changes")
 
-	diff, err := g.repo.GetFileDiff(path)
+	diff, err := g.redactor.Diff(path, g.repo.GetFileDiff)
 	if err != nil {
 		logger.Error().
 			Err(err).
 			Str("path", path).
 			Msg("Failed to get file diff")
-		return "", errors.Wrap(errors.CodeGitError, err)
-	}
-
-	filteredDiff, hasSignificantChanges := g.filterImportChanges(diff)
-
-	input := map[string]interface{}{
-		"file":                  path,
-		"status":                git.GetFileStatus(status),
-		"diff":                  filteredDiff,
-		"hasSignificantChanges": hasSignificantChanges,
+		return fileChange{}, errors.Wrap(errors.CodeGitError, err)
 	}
 
-	tool := g.findFunction("generate_file_summary")
-	if tool == nil {
-		logger.Error().
-			Str("function", "generate_file_summary").
-			Msg("Required function not found in configuration")
-		return "", errors.WrapWithContext(
-			errors.CodeConfigError,
-			errors.ErrInvalidInput,
-			"generate_file_summary function not found in configuration",
-		)
-	}
+	return fileChange{
+		path:  path,
+		input: llm.FileSummaryInput(path, git.GetFileStatus(status), diff),
+	}, nil
+}
 
+func (g *Commit) getFileSummary(ctx context.Context, tool *config.LLMFunction, change fileChange) (string, error) {
 	logger.Debug().
-		Interface("input", input).
+		Interface("input", change.input).
 		Msg("Analyzing file changes")
 
-	summary, err := llm.CallFunction(ctx, g.llm, tool, input)
+	diff, _ := change.input[diffField].(string)
+	summary, err := g.summarizer.SummarizeFile(ctx, tool, change.input, diffField, git.SplitHunks(diff), summaryField)
 	if err != nil {
 		logger.Error().
 			Err(err).
-			Str("path", path).
+			Str("path", change.path).
 			Msg("Failed to analyze changes using LLM")
-		return "", errors.Wrap(errors.CodeLLMError, err)
+		return "", errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			"failed to generate summary for "+change.path,
+		)
 	}
 
 	return summary, nil
@@ -346,13 +395,36 @@ func (g *Commit) getFileSummaries(ctx context.Context) (map[string]string, error
 		return nil, errors.Wrap(errors.CodeGitError, err)
 	}
 
-	fileSummaries := make(map[string]string)
-	for path, fileStatus := range status {
-		if g.shouldIgnoreFile(path) {
-			continue
+	tool := g.findFunction("generate_file_summary")
+	if tool == nil {
+		logger.Error().
+			Str("function", "generate_file_summary").
+			Msg("Required function not found in configuration")
+		return nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidInput,
+			"generate_file_summary function not found in configuration",
+		)
+	}
+
+	paths := make([]string, 0, len(status))
+	g.changedFiles = make([]string, 0, len(status))
+	for path := range status {
+		g.changedFiles = append(g.changedFiles, path)
+		if !g.shouldIgnoreFile(path) {
+			paths = append(paths, path)
 		}
+	}
+	sort.Strings(paths)
 
-		summary, err := g.getFileSummary(ctx, path, fileStatus.Staging)
+	// Summaries may only mention files that are part of the change
+	ctx = llm.WithOutputCheck(ctx, llm.ReferenceCheck(g.changedFiles))
+
+	// Diffs are read sequentially since the worktree isn't safe for concurrent use,
+	// only the LLM calls run in parallel
+	changes := make([]fileChange, 0, len(paths))
+	for _, path := range paths {
+		change, err := g.prepareFileChange(path, status.File(path).Staging)
 		if err != nil {
 			return nil, errors.WrapWithContext(
 				errors.CodeGitError,
@@ -360,11 +432,10 @@ func (g *Commit) getFileSummaries(ctx context.Context) (map[string]string, error
 				"failed to generate summary for "+path,
 			)
 		}
-
-		fileSummaries[path] = summary
+		changes = append(changes, change)
 	}
 
-	if len(fileSummaries) == 0 {
+	if len(changes) == 0 {
 		return nil, errors.WrapWithContext(
 			errors.CodeNoChanges,
 			errors.ErrInvalidInput,
@@ -372,6 +443,20 @@ func (g *Commit) getFileSummaries(ctx context.Context) (map[string]string, error
 		)
 	}
 
+	summaries, err := llm.RunConcurrent(ctx, g.cfg.LLM.Concurrency, changes,
+		func(ctx context.Context, change fileChange) (string, error) {
+			return g.getFileSummary(ctx, tool, change)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSummaries := make(map[string]string, len(changes))
+	for i, change := range changes {
+		fileSummaries[change.path] = summaries[i]
+	}
+
 	return fileSummaries, nil
 }
 
@@ -399,6 +484,11 @@ func (g *Commit) getCommitMessage(ctx context.Context, summary string) (string,
 			maxRetries = 1
 		}
 
+		// Stream the message to the preview while it is generated
+		if g.cfg.LLM.Stream && g.preview != nil {
+			ctx = llm.WithStreamHandler(ctx, llm.FieldPreview(messageField, g.preview))
+		}
+
 		var lastMessage string
 		var lastError string
 
@@ -430,8 +520,11 @@ func (g *Commit) getCommitMessage(ctx context.Context, summary string) (string,
 				input["error"] = lastError
 			}
 
-			message, err := llm.CallFunction(ctx, g.llm, currentFunction, input)
+			// This loop re-prompts with the error already, so CallFunction makes a single attempt
+			// rather than repairing invalid output on its own, which would multiply the calls
+			result, err := llm.CallFunction(ctx, g.llm, currentFunction, input, 1)
 			if err != nil {
+				lastError = err.Error()
 				logger.Debug().
 					Err(err).
 					Int("attempt", retries+1).
@@ -439,7 +532,12 @@ func (g *Commit) getCommitMessage(ctx context.Context, summary string) (string,
 				continue
 			}
 
-			message = cleanCommitMessage(message)
+			message := overrideHeader(cleanCommitMessage(result.String(messageField)), &g.cfg.Commit)
+			if g.cfg.Commit.PostProcess {
+				if message, err = g.postProcessMessage(message); err != nil {
+					return "", err
+				}
+			}
 
 			// INFO log for the proposed commit message
 			logger.Info().
@@ -481,6 +579,15 @@ func (g *Commit) getCommitMessage(ctx context.Context, summary string) (string,
 	}
 }
 
+// postProcessMessage renders a generated message through commit.template
+func (g *Commit) postProcessMessage(message string) (string, error) {
+	data, err := g.collectChangeData()
+	if err != nil {
+		return "", err
+	}
+	return g.postProcess(message, data)
+}
+
 // ValidateCommitMessage handles all commit message validation with detailed feedback
 func (g *Commit) ValidateCommitMessage(message string) CommitValidationResult {
 	if message == "" {
@@ -585,35 +692,6 @@ func (g *Commit) analyzeInvalidMessage(message string) string {
 	return result.Message
 }
 
-func (*Commit) filterImportChanges(diff string) (string, bool) {
-	lines := strings.Split(diff, "\n")
-	var filteredLines []string
-	inImportBlock := false
-	significantChanges := false
-
-	for _, line := range lines {
-		if strings.HasPrefix(line, "import (") {
-			inImportBlock = true
-		} else if inImportBlock && strings.HasPrefix(line, ")") {
-			inImportBlock = false
-		}
-
-		if inImportBlock {
-			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
-				filteredLines = append(filteredLines, line)
-				significantChanges = true
-			}
-		} else {
-			filteredLines = append(filteredLines, line)
-			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
-				significantChanges = true
-			}
-		}
-	}
-
-	return strings.Join(filteredLines, "\n"), significantChanges
-}
-
 func (g *Commit) isValidCommitMessage(message string) bool {
 	result := g.ValidateCommitMessage(message)
 	if !result.Valid {
@@ -633,6 +711,12 @@ func (g *Commit) ClearManualMessage() {
 	g.manualMessage = ""
 }
 
+// SetPreview sets the handler that receives the commit message while it is streamed,
+// used only when llm.stream is enabled
+func (g *Commit) SetPreview(handler llm.StreamHandler) {
+	g.preview = handler
+}
+
 func cleanCommitMessage(message string) string {
 	// Remove any markdown formatting
 	message = strings.ReplaceAll(message, "`", "")
@@ -677,6 +761,31 @@ func validateConfig(cfg *config.Config) error {
 		)
 	}
 
+	switch cfg.Commit.Mode {
+	case "", config.CommitModeLLM, config.CommitModeTemplate:
+	default:
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextInvalidCommitMode, cfg.Commit.Mode),
+		)
+	}
+
+	if cfg.Commit.Type != "" && !regexp.MustCompile(`^(`+validTypes+`)$`).MatchString(cfg.Commit.Type) {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			"invalid commit type: "+cfg.Commit.Type,
+		)
+	}
+	if cfg.Commit.Scope != "" && !regexp.MustCompile(`^`+validScope+`$`).MatchString(cfg.Commit.Scope) {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			"invalid commit scope: "+cfg.Commit.Scope,
+		)
+	}
+
 	requiredFunctions := []string{"generate_file_summary", "generate_commit_message"}
 	for _, function := range requiredFunctions {
 		if !hasFunctionConfig(cfg.Functions, function) {
@@ -0,0 +1,129 @@
+package commit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/git"
+	"codeberg.org/mutker/bumpa/internal/llm"
+	"codeberg.org/mutker/bumpa/internal/llm/llmtest"
+)
+
+const (
+	generateFunction = "generate_commit_message"
+	retryFunction    = "retry_commit_message"
+)
+
+// newTestGenerator returns a generator for a fresh repository that talks to server
+func newTestGenerator(t *testing.T, server *llmtest.Server) *Commit {
+	t.Helper()
+
+	cfg := loadConfig(t)
+	cfg.LLM = *server.Config()
+	dir := initRepo(t, map[string]string{"greet.go": greetBefore})
+
+	client, err := llm.New(&cfg.LLM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := git.OpenRepository(dir, cfg.Git)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator, err := NewGenerator(cfg, client, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return generator
+}
+
+func TestGetCommitMessageRetriesInvalidMessage(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.Script(generateFunction, toolCall(t, map[string]interface{}{"message": "Added a goodbye function."}))
+	server.Script(retryFunction, toolCall(t, map[string]interface{}{"message": "feat(greet): add goodbye function"}))
+
+	message, err := newTestGenerator(t, server).getCommitMessage(context.Background(), "greet.go: Adds Goodbye")
+	if err != nil {
+		t.Fatalf("getCommitMessage: %v", err)
+	}
+	if want := "feat(greet): add goodbye function"; message != want {
+		t.Errorf("got %q, want %q", message, want)
+	}
+	if calls := server.Calls(generateFunction); calls != 1 {
+		t.Errorf("got %d %s requests, want 1", calls, generateFunction)
+	}
+	if calls := server.Calls(retryFunction); calls != 1 {
+		t.Errorf("got %d %s requests, want 1", calls, retryFunction)
+	}
+}
+
+func TestGetCommitMessageRetriesFailedRequest(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.Script(generateFunction, llmtest.Malformed())
+	server.Script(retryFunction,
+		llmtest.ServerError(http.StatusBadGateway, "upstream unavailable"),
+		toolCall(t, map[string]interface{}{"message": "feat(greet): add goodbye function"}),
+	)
+
+	message, err := newTestGenerator(t, server).getCommitMessage(context.Background(), "greet.go: Adds Goodbye")
+	if err != nil {
+		t.Fatalf("getCommitMessage: %v", err)
+	}
+	if want := "feat(greet): add goodbye function"; message != want {
+		t.Errorf("got %q, want %q", message, want)
+	}
+}
+
+func TestGetCommitMessageMakesOneCallPerAttempt(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.Script(llmtest.DefaultFunction, toolCall(t, map[string]interface{}{"text": "not the message field"}))
+
+	generator := newTestGenerator(t, server)
+	_, err := generator.getCommitMessage(context.Background(), "greet.go: Adds Goodbye")
+	if errors.GetCode(err) != errors.CodeLLMGenFailed {
+		t.Fatalf("got %v, want %s", err, errors.CodeLLMGenFailed)
+	}
+
+	calls := server.Calls(generateFunction) + server.Calls(retryFunction)
+	if want := generator.cfg.LLM.MaxRetries; calls != want {
+		t.Errorf("got %d requests, want one per attempt (%d)", calls, want)
+	}
+}
+
+func TestPostProcessKeepsBreakingMarker(t *testing.T) {
+	cfg := loadConfig(t)
+	tmpl, _, err := parseTemplate(&cfg.Commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator := &Commit{cfg: cfg, template: tmpl}
+
+	tests := map[string]string{
+		"feat(api)!: remove the v1 endpoints": "feat(api)!: remove the v1 endpoints",
+		"fix!: change the default port":       "fix!: change the default port",
+		"fix(api): fix empty body handling":   "fix(api): fix empty body handling",
+	}
+	for message, want := range tests {
+		got, err := generator.postProcess(message, &changeData{Files: []string{"api/server.go"}})
+		if err != nil {
+			t.Fatalf("postProcess(%q): %v", message, err)
+		}
+		if got != want {
+			t.Errorf("postProcess(%q) = %q, want %q", message, got, want)
+		}
+		if result := generator.ValidateCommitMessage(got); !result.Valid {
+			t.Errorf("ValidateCommitMessage(%q): %s", got, result.Message)
+		}
+	}
+
+	for _, invalid := range []string{"feat(api)!!: remove the v1 endpoints", "feat!(api): remove the v1 endpoints"} {
+		if result := generator.ValidateCommitMessage(invalid); result.Valid {
+			t.Errorf("ValidateCommitMessage(%q) accepted a misplaced breaking marker", invalid)
+		}
+	}
+}
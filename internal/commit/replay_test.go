@@ -0,0 +1,169 @@
+package commit
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/git"
+	"codeberg.org/mutker/bumpa/internal/llm"
+	"codeberg.org/mutker/bumpa/internal/llm/llmtest"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var update = flag.Bool("update", false, "record the fixtures in testdata from scripted responses")
+
+const (
+	greetBefore = "package greet\n\nfunc Hello() string {\n\treturn \"hello\"\n}\n"
+	greetAfter  = greetBefore + "\nfunc Goodbye() string {\n\treturn \"goodbye\"\n}\n"
+)
+
+// TestGenerateReplay generates a commit message for a staged change from the recorded
+// fixtures in testdata/generate. Run with -update to record them again after changing the
+// prompts.
+func TestGenerateReplay(t *testing.T) {
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "generate"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadConfig(t)
+	dir := initRepo(t, map[string]string{"greet.go": greetBefore})
+	stage(t, dir, "greet.go", greetAfter)
+
+	client := replayClient(t, fixtures, func(server *llmtest.Server) {
+		server.Script("generate_file_summary", toolCall(t, map[string]interface{}{
+			"summary": "Adds a Goodbye function next to Hello",
+		}))
+		server.Script("generate_commit_message", toolCall(t, map[string]interface{}{
+			"message": "feat(greet): add goodbye function",
+		}))
+	})
+
+	repo, err := git.OpenRepository(dir, cfg.Git)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generator, err := NewGenerator(cfg, client, repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := generator.Generate(context.Background())
+	if err != nil {
+		t.Fatalf("Generate: %v", err)
+	}
+	if want := "feat(greet): add goodbye function"; message != want {
+		t.Errorf("got %q, want %q", message, want)
+	}
+}
+
+// replayClient replays the fixtures in dir. With -update, they are recorded first from a
+// server scripted by script.
+func replayClient(t *testing.T, dir string, script func(*llmtest.Server)) llm.Client {
+	t.Helper()
+
+	if !*update {
+		return llm.NewReplayClient(dir)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	server := llmtest.NewServer()
+	t.Cleanup(server.Close)
+	script(server)
+
+	client, err := llm.New(server.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return llm.NewRecordingClient(client, dir)
+}
+
+// loadConfig loads testdata/bumpa.yaml over the defaults, ignoring any user configuration
+func loadConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	args, err := config.ParseArgs([]string{"--config", filepath.Join("testdata", "bumpa.yaml"), "commit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// initRepo creates a repository with files committed on master and makes it the working
+// directory for the rest of the test
+func initRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path := range files {
+		stage(t, dir, path, files[path])
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = worktree.Commit("chore: Initial commit", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+
+	return dir
+}
+
+// stage writes content to path in the repository at dir and adds it to the index
+func stage(t *testing.T, dir, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := worktree.Add(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// toolCall scripts a tool call answering with args
+func toolCall(t *testing.T, args map[string]interface{}) llmtest.Response {
+	t.Helper()
+
+	response, err := llmtest.ToolCall(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
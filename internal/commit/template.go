@@ -0,0 +1,339 @@
+package commit
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/git"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const maxNamedFiles = 2 // Files named in the subject, more are counted instead
+
+// nonWordPattern matches runs of characters not allowed in scopes and descriptions
+var nonWordPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// headerPattern splits a Conventional Commits header into type, scope, breaking marker and
+// description
+var headerPattern = regexp.MustCompile(`^([a-z]+)(?:\(([^)]*)\))?(!?): (.+)$`)
+
+// pathTypes maps path patterns to the commit type of files matching them, in order of precedence
+var pathTypes = []struct {
+	commitType string
+	pattern    *regexp.Regexp
+}{
+	{"test", regexp.MustCompile(`(^|/)(tests?|testdata|__tests__)/|_test\.go$|\.(test|spec)\.[a-z]+$`)},
+	{"ci", regexp.MustCompile(`^\.(github/workflows|gitlab-ci\.yml|woodpecker|forgejo/workflows|circleci)`)},
+	{"docs", regexp.MustCompile(`(^|/)docs?/|\.(md|rst|adoc)$|(^|/)(LICENSE|AUTHORS|CHANGELOG)[^/]*$`)},
+	{"build", regexp.MustCompile(`(^|/)(Makefile|Dockerfile|go\.(mod|sum)|package(-lock)?\.json|` +
+		`Cargo\.(toml|lock)|pyproject\.toml|pom\.xml|[^/]+\.gradle)$`)},
+}
+
+// templateFuncs are available in commit.template in addition to the built-in functions
+var templateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"join":  strings.Join,
+	"base":  path.Base,
+	"dir":   path.Dir,
+}
+
+// changeData is the structured view of a change that commit.template is rendered over
+type changeData struct {
+	Type        string   // Conventional Commit type, inferred from the paths or taken from the LLM
+	Scope       string   // Last element of the directory all files share, or the LLM's scope
+	Breaking    bool     // The LLM's message has the ! breaking change marker
+	Verb        string   // add, remove or update depending on the file statuses
+	Subject     string   // Changed file names, or their count if there are many
+	Description string   // Verb and subject, or the LLM's description
+	Message     string   // The LLM message being post-processed, empty otherwise
+	Files       []string // Changed paths, sorted
+	Added       int
+	Modified    int
+	Deleted     int
+	Renamed     int
+	Branch      string
+	Ticket      string // Ticket matched in the branch name by commit.ticket_pattern
+}
+
+// parseTemplate compiles commit.template and the ticket pattern
+func parseTemplate(cfg *config.CommitConfig) (*template.Template, *regexp.Regexp, error) {
+	tmpl, err := template.New("commit").Funcs(templateFuncs).Parse(cfg.Template)
+	if err != nil {
+		return nil, nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			errors.FormatContext(errors.ContextInvalidTemplate, "commit.template"),
+		)
+	}
+
+	var ticket *regexp.Regexp
+	if cfg.TicketPattern != "" {
+		if ticket, err = regexp.Compile(cfg.TicketPattern); err != nil {
+			return nil, nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextInvalidTemplate, "commit.ticket_pattern"),
+			)
+		}
+	}
+
+	return tmpl, ticket, nil
+}
+
+// generateFromTemplate renders commit.template over the staged changes without sending
+// anything to an LLM
+func (g *Commit) generateFromTemplate() (string, error) {
+	data, err := g.collectChangeData()
+	if err != nil {
+		return "", err
+	}
+
+	message, err := g.renderMessage(data)
+	if err != nil {
+		return "", err
+	}
+
+	// Long subjects are replaced by the file count to keep the header within its limit
+	if header, _, _ := strings.Cut(message, "\n"); len(header) > maxHeaderLength && len(data.Files) > 1 {
+		data.Subject = fmt.Sprintf("%d files", len(data.Files))
+		data.Description = data.Verb + " " + data.Subject
+		if message, err = g.renderMessage(data); err != nil {
+			return "", err
+		}
+	}
+
+	logger.Info().
+		Str("message", message).
+		Int("files", len(data.Files)).
+		Msg("Rendered commit message from template")
+
+	return message, nil
+}
+
+// postProcess renders an LLM generated message through commit.template, taking type, scope,
+// breaking marker and description from the message and everything else from the change data
+func (g *Commit) postProcess(message string, data *changeData) (string, error) {
+	processed := *data
+	processed.Message = message
+	processed.Description = message
+	if match := headerPattern.FindStringSubmatch(message); match != nil {
+		processed.Type = match[1]
+		processed.Scope = match[2]
+		processed.Breaking = match[3] == "!"
+		processed.Description = match[4]
+	}
+	processed.applyOverrides(&g.cfg.Commit)
+
+	return g.renderMessage(&processed)
+}
+
+// collectChangeData gathers the non-ignored changed files and derives the template data
+func (g *Commit) collectChangeData() (*changeData, error) {
+	status, err := g.repo.Status()
+	if err != nil {
+		return nil, errors.Wrap(errors.CodeGitError, err)
+	}
+
+	statuses := make(map[string]git.StatusCode, len(status))
+	for path, fileStatus := range status {
+		if !g.shouldIgnoreFile(path) {
+			statuses[path] = fileStatus.Staging
+		}
+	}
+
+	if len(statuses) == 0 {
+		return nil, errors.WrapWithContext(
+			errors.CodeNoChanges,
+			errors.ErrInvalidInput,
+			errors.ContextNoChanges,
+		)
+	}
+
+	data := newChangeData(statuses)
+	data.applyOverrides(&g.cfg.Commit)
+
+	data.Branch, err = g.getCurrentBranch()
+	if err != nil {
+		logger.Warn().Err(err).Msg("failed to get current branch name")
+	}
+	if g.ticketPattern != nil {
+		match := g.ticketPattern.FindStringSubmatch(data.Branch)
+		switch {
+		case len(match) > 1:
+			data.Ticket = match[1]
+		case match != nil:
+			data.Ticket = match[0]
+		}
+	}
+
+	return data, nil
+}
+
+// newChangeData derives type, scope, verb, subject and status counts from the changed paths
+func newChangeData(statuses map[string]git.StatusCode) *changeData {
+	data := &changeData{Files: make([]string, 0, len(statuses))}
+	for file, status := range statuses {
+		data.Files = append(data.Files, file)
+		switch status {
+		case git.Added, git.Untracked:
+			data.Added++
+		case git.Deleted:
+			data.Deleted++
+		case git.Renamed, git.Copied:
+			data.Renamed++
+		default:
+			data.Modified++
+		}
+	}
+	sort.Strings(data.Files)
+
+	data.Verb = data.inferVerb()
+	data.Type = data.inferType()
+	data.Scope = inferScope(data.Files)
+	data.Subject = subjectFor(data.Files)
+	data.Description = data.Verb + " " + data.Subject
+
+	return data
+}
+
+// applyOverrides replaces the type and scope with those given by commit --type and --scope
+func (d *changeData) applyOverrides(cfg *config.CommitConfig) {
+	if cfg.Type != "" {
+		d.Type = cfg.Type
+	}
+	if cfg.Scope != "" {
+		d.Scope = cfg.Scope
+	}
+}
+
+// overrideHeader replaces the type and scope of a generated message with those given by
+// commit --type and --scope, keeping the breaking marker, description and body
+func overrideHeader(message string, cfg *config.CommitConfig) string {
+	if cfg.Type == "" && cfg.Scope == "" {
+		return message
+	}
+
+	header, body, hasBody := strings.Cut(message, "\n")
+	match := headerPattern.FindStringSubmatch(header)
+	if match == nil {
+		return message
+	}
+
+	data := changeData{Type: match[1], Scope: match[2]}
+	data.applyOverrides(cfg)
+	header = data.Type
+	if data.Scope != "" {
+		header += "(" + data.Scope + ")"
+	}
+	header += match[3] + colonWithSpace + match[4]
+
+	if hasBody {
+		return header + "\n" + body
+	}
+	return header
+}
+
+// inferType returns the type shared by all files' paths, feat for new files and chore otherwise
+func (d *changeData) inferType() string {
+	shared := ""
+	for i, file := range d.Files {
+		fileType := "chore"
+		for _, pt := range pathTypes {
+			if pt.pattern.MatchString(file) {
+				fileType = pt.commitType
+				break
+			}
+		}
+		if i > 0 && fileType != shared {
+			shared = "chore"
+			break
+		}
+		shared = fileType
+	}
+
+	if shared == "chore" && d.Verb == "add" {
+		return "feat"
+	}
+	return shared
+}
+
+// inferVerb returns add or remove if every file was added or deleted, and update otherwise
+func (d *changeData) inferVerb() string {
+	switch len(d.Files) {
+	case d.Added:
+		return "add"
+	case d.Deleted:
+		return "remove"
+	default:
+		return "update"
+	}
+}
+
+// inferScope returns the last element of the deepest directory containing every file
+func inferScope(files []string) string {
+	common := path.Dir(files[0])
+	for _, file := range files[1:] {
+		for common != "." && !strings.HasPrefix(file, common+"/") {
+			common = path.Dir(common)
+		}
+	}
+	if common == "." {
+		return ""
+	}
+
+	scope := strings.Trim(nonWordPattern.ReplaceAllString(strings.ToLower(path.Base(common)), "-"), "-")
+	scope = strings.TrimLeft(scope, "0123456789-")
+	return scope
+}
+
+// subjectFor names up to maxNamedFiles files by base name, and counts them otherwise. Files
+// sharing a name, like go.mod and go.sum, are named once.
+func subjectFor(files []string) string {
+	if len(files) > maxNamedFiles {
+		return fmt.Sprintf("%d files", len(files))
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if word := fileWord(file); !slices.Contains(names, word) {
+			names = append(names, word)
+		}
+	}
+	return strings.Join(names, " and ")
+}
+
+// fileWord turns a path into a lowercase word usable in a description
+func fileWord(file string) string {
+	base := path.Base(file)
+	if name := strings.TrimSuffix(base, path.Ext(base)); name != "" {
+		base = name
+	}
+
+	word := strings.Trim(nonWordPattern.ReplaceAllString(strings.ToLower(base), "-"), "-")
+	if word == "" {
+		return "file"
+	}
+	return word
+}
+
+// renderMessage executes commit.template over data
+func (g *Commit) renderMessage(data *changeData) (string, error) {
+	var message strings.Builder
+	if err := g.template.Execute(&message, data); err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			errors.FormatContext(errors.ContextInvalidTemplate, "commit.template"),
+		)
+	}
+
+	return strings.TrimSpace(message.String()), nil
+}
@@ -0,0 +1,494 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"github.com/spf13/viper"
+)
+
+// Default release channels of --pre, least stable first
+const (
+	ChannelAlpha = "alpha"
+	ChannelBeta  = "beta"
+	ChannelRC    = "rc"
+)
+
+// DefaultChannels lists the release channels in the order they progress, unless
+// version.prerelease lists others
+var DefaultChannels = []string{ChannelAlpha, ChannelBeta, ChannelRC}
+
+// CommitTypes lists the Conventional Commit types accepted by commit --type
+var CommitTypes = []string{"feat", "fix", "docs", "style", "refactor", "perf", "test", "chore", "ci", "build"}
+
+// flagAlias is a short flag for a frequently overridden configuration key
+type flagAlias struct {
+	name  string
+	arg   string // Placeholder of the value in help output, empty for booleans
+	key   string
+	usage string
+}
+
+// flagAliases are the short flags in help order. Every key also has a flag named after its
+// full path, such as --llm-base-url.
+var flagAliases = []flagAlias{
+	{"profile", "name", "profile", "Configuration profile, chosen by the remote URL if not set"},
+	{"model", "name", "llm.model", "LLM model name"},
+	{"base-url", "url", "llm.base_url", "Base URL of the LLM API"},
+	{"provider", "name", "llm.provider", "LLM provider, openai-compatible or ollama"},
+	{"max-diff-lines", "n", "git.max_diff_lines", "Maximum diff lines per file, 0 fits the context window"},
+	{"log-level", "level", "logging.level", "Log level: debug, info, warn, error or fatal"},
+	{"offline", "", "offline", "Run without an LLM using deterministic fallbacks"},
+	{"no-confirm", "", "no_confirm", "Skip confirmation prompts"},
+	{"alpha", "", "version.alpha", "Release on the alpha channel, same as version --pre alpha"},
+	{"beta", "", "version.beta", "Release on the beta channel, same as version --pre beta"},
+	{"rc", "", "version.rc", "Release a candidate, same as version --pre rc"},
+}
+
+// secretKeys never get a flag, command lines are visible to other processes
+var secretKeys = []string{"llm.api_key"}
+
+// Command is a subcommand with its own flags
+type Command struct {
+	Name    string
+	Usage   string   // Arguments after the name
+	Summary string   // One line description for help output
+	Actions []string // Positional actions, offered by shell completion
+	Flags   []CommandFlag
+}
+
+// CommandFlag is a flag only accepted by one command
+type CommandFlag struct {
+	Name   string
+	Arg    string // Placeholder of the value in help output
+	Usage  string
+	Values []string // Accepted values, also offered by shell completion
+	Open   bool     // Values are only suggestions, others are accepted as well
+	set    func(cfg *Config, value string)
+}
+
+// Commands lists the subcommands in help order
+var Commands = []Command{
+	{
+		Name:    "commit",
+		Summary: "Generate a message for the staged changes and commit them",
+		Flags: []CommandFlag{
+			{
+				Name:   "type",
+				Arg:    "type",
+				Usage:  "Conventional Commit type of the message",
+				Values: CommitTypes,
+				set:    func(cfg *Config, value string) { cfg.Commit.Type = value },
+			},
+			{
+				Name:  "scope",
+				Arg:   "scope",
+				Usage: "Conventional Commit scope of the message",
+				set:   func(cfg *Config, value string) { cfg.Commit.Scope = value },
+			},
+		},
+	},
+	{
+		Name:    "version",
+		Summary: "Suggest the next semantic version, then update files, commit and tag",
+		Flags: []CommandFlag{
+			{
+				Name:   "bump",
+				Arg:    "component",
+				Usage:  "Version component to increment, skipping analysis",
+				Values: []string{"major", "minor", "patch"},
+				set:    func(cfg *Config, value string) { cfg.Version.Bump = value },
+			},
+			{
+				Name:   "pre",
+				Arg:    "channel",
+				Usage:  "Release channel of the new version, from version.prerelease",
+				Values: DefaultChannels,
+				Open:   true,
+				set:    func(cfg *Config, value string) { cfg.Version.Pre = value },
+			},
+		},
+	},
+	{
+		Name:    "init",
+		Summary: "Detect the project and write .bumpa.yaml, optionally installing the commit hook",
+	},
+	{
+		Name:    "cache",
+		Usage:   "[stats|clear]",
+		Summary: "Show statistics of the LLM response cache or clear it",
+		Actions: []string{"stats", "clear"},
+	},
+	{
+		Name:    "config",
+		Usage:   "show-defaults | explain <key> | validate | migrate | schema",
+		Summary: "Print built-in functions, explain where a setting comes from, or validate, migrate or print the schema of the configuration",
+		Actions: []string{"show-defaults", "explain", "validate", "migrate", "schema"},
+	},
+	{
+		Name:    "completion",
+		Usage:   "bash|zsh|fish",
+		Summary: "Print the shell completion script",
+		Actions: []string{"bash", "zsh", "fish"},
+	},
+}
+
+// Args is the parsed command line
+type Args struct {
+	Command    string
+	Args       []string // Positional arguments after the command
+	ConfigFile string   // Replaces the repository configuration file if set
+	overrides  map[string]string
+	options    map[string]string // Values of command flags by name
+	origins    []flagOrigin
+}
+
+type flagOrigin struct {
+	key  string
+	flag string
+}
+
+// overrideFlag sets a configuration key, validating the value against the type of its default
+type overrideFlag struct {
+	args  *Args
+	key   string
+	name  string
+	kind  interface{} // Default value, only its type is used
+	value string
+}
+
+func (f *overrideFlag) String() string {
+	return f.value
+}
+
+func (f *overrideFlag) Set(value string) error {
+	if expected := checkKind(f.kind, value); expected != "" {
+		return fmt.Errorf("%s expects %s", f.key, expected)
+	}
+
+	f.value = value
+	f.args.overrides[f.key] = value
+	f.args.origins = append(f.args.origins, flagOrigin{key: f.key, flag: "--" + f.name})
+	return nil
+}
+
+// checkKind returns what is expected of value if it cannot be decoded into the type of kind,
+// the default of the key it sets
+func checkKind(kind interface{}, value string) string {
+	var err error
+	var expected string
+	switch kind.(type) {
+	case bool:
+		_, err = strconv.ParseBool(value)
+		expected = "true or false"
+	case int, int64:
+		_, err = strconv.Atoi(value)
+		expected = "an integer"
+	case float64:
+		_, err = strconv.ParseFloat(value, 64)
+		expected = "a number"
+	case time.Duration:
+		_, err = time.ParseDuration(value)
+		expected = "a duration such as 30s"
+	}
+	if err != nil {
+		return expected
+	}
+	return ""
+}
+
+func (f *overrideFlag) IsBoolFlag() bool {
+	_, ok := f.kind.(bool)
+	return ok
+}
+
+// optionFlag records the value of a command flag
+type optionFlag struct {
+	args  *Args
+	flag  *CommandFlag
+	value string
+}
+
+func (f *optionFlag) String() string {
+	return f.value
+}
+
+func (f *optionFlag) Set(value string) error {
+	if len(f.flag.Values) > 0 && !f.flag.Open && !slices.Contains(f.flag.Values, value) {
+		return fmt.Errorf("invalid value %q, expected one of %s", value, strings.Join(f.flag.Values, ", "))
+	}
+	f.value = value
+	f.args.options[f.flag.Name] = value
+	return nil
+}
+
+// ParseArgs parses the global flags, the command and the flags of the command. Global flags
+// are accepted before and after the command.
+func ParseArgs(arguments []string) (*Args, error) {
+	args := &Args{
+		overrides: make(map[string]string),
+		options:   make(map[string]string),
+	}
+
+	global := args.newFlagSet(appName, nil)
+	if err := parseFlags(global, arguments); err != nil {
+		return nil, err
+	}
+
+	if global.NArg() == 0 {
+		return nil, errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			errors.ContextNoCommand,
+		)
+	}
+
+	args.Command = global.Arg(0)
+	rest := global.Args()[1:]
+
+	// help <command> is the same as <command> --help
+	if args.Command == "help" {
+		if len(rest) == 0 {
+			global.Usage()
+			return nil, errors.ErrHelpShown
+		}
+		args.Command, rest = rest[0], []string{"--help"}
+	}
+
+	command := FindCommand(args.Command)
+	commandSet := args.newFlagSet(appName+" "+args.Command, command)
+	if err := parseFlags(commandSet, rest); err != nil {
+		return nil, err
+	}
+	args.Args = commandSet.Args()
+
+	if err := args.validate(); err != nil {
+		return nil, err
+	}
+
+	return args, nil
+}
+
+// parseFlags parses arguments into flagSet, which has already printed the usage or the
+// problem when it fails. A request for help is reported as ErrHelpShown.
+func parseFlags(flagSet *flag.FlagSet, arguments []string) error {
+	if err := flagSet.Parse(arguments); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errors.ErrHelpShown
+		}
+		return errors.Wrap(errors.CodeInputError, err)
+	}
+	return nil
+}
+
+// FindCommand returns the command named name, or nil if there is none
+func FindCommand(name string) *Command {
+	for i := range Commands {
+		if Commands[i].Name == name {
+			return &Commands[i]
+		}
+	}
+	return nil
+}
+
+// newFlagSet creates a flag set with the global flags and the flags of command, if any
+func (a *Args) newFlagSet(name string, command *Command) *flag.FlagSet {
+	flagSet := flag.NewFlagSet(name, flag.ContinueOnError)
+	flagSet.StringVar(&a.ConfigFile, "config", a.ConfigFile, "Configuration file used instead of .bumpa.yaml")
+
+	defaults := flagDefaults()
+	for _, alias := range flagAliases {
+		flagSet.Var(&overrideFlag{args: a, key: alias.key, name: alias.name, kind: defaults[alias.key]},
+			alias.name, alias.usage)
+	}
+	for _, key := range overrideKeys() {
+		name := FlagName(key)
+		if flagSet.Lookup(name) == nil {
+			flagSet.Var(&overrideFlag{args: a, key: key, name: name, kind: defaults[key]}, name, "Set "+key)
+		}
+	}
+
+	if command != nil {
+		for i := range command.Flags {
+			commandFlag := &command.Flags[i]
+			flagSet.Var(&optionFlag{args: a, flag: commandFlag}, commandFlag.Name, commandFlag.Usage)
+		}
+	}
+
+	flagSet.Usage = func() {
+		if command != nil {
+			printCommandUsage(flagSet.Output(), command)
+			return
+		}
+		printUsage(flagSet.Output())
+	}
+
+	return flagSet
+}
+
+// validate rejects conflicting release channels
+func (a *Args) validate() error {
+	var channels []string
+	for _, channel := range DefaultChannels {
+		if value, ok := a.overrides["version."+channel]; ok {
+			if enabled, _ := strconv.ParseBool(value); enabled {
+				channels = append(channels, channel)
+			}
+		}
+	}
+	if pre, ok := a.options["pre"]; ok && !slices.Contains(channels, pre) {
+		channels = append(channels, pre)
+	}
+
+	if len(channels) > 1 {
+		return errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			"only one of --alpha, --beta, --rc or --pre can be specified",
+		)
+	}
+
+	return nil
+}
+
+// apply sets the configuration keys of the global flags on v
+func (a *Args) apply(v *viper.Viper) {
+	for key, value := range a.overrides {
+		v.Set(key, value)
+	}
+}
+
+// applyOptions copies the command flags, command and positional arguments into cfg and
+// records the flags as the highest configuration layer
+func (a *Args) applyOptions(cfg *Config) {
+	cfg.Command = a.Command
+	cfg.Args = a.Args
+
+	if command := FindCommand(a.Command); command != nil {
+		for i := range command.Flags {
+			if value, ok := a.options[command.Flags[i].Name]; ok {
+				command.Flags[i].set(cfg, value)
+			}
+		}
+	}
+
+	if cfg.Sources == nil {
+		cfg.Sources = make(Sources)
+	}
+	for _, origin := range a.origins {
+		cfg.Sources.add(origin.key, Origin{Layer: LayerFlag, Source: origin.flag, Value: a.overrides[origin.key]})
+	}
+}
+
+// FlagName returns the flag that overrides key
+func FlagName(key string) string {
+	return strings.NewReplacer(".", "-", "_", "-").Replace(key)
+}
+
+// isAlias reports whether name is one of flagAliases
+func isAlias(name string) bool {
+	return slices.ContainsFunc(flagAliases, func(alias flagAlias) bool {
+		return alias.name == name
+	})
+}
+
+// flagDefaults returns the default of every key with one
+func flagDefaults() map[string]interface{} {
+	defaults := viper.New()
+	setDefaults(defaults)
+	return flatten("", defaults.AllSettings())
+}
+
+// overrideKeys returns every configuration key that can be set by a flag, sorted
+func overrideKeys() []string {
+	keys := make([]string, 0, len(envMappings))
+	for key := range flagDefaults() {
+		keys = append(keys, key)
+	}
+	for key := range envMappings {
+		if !slices.Contains(keys, key) {
+			keys = append(keys, key)
+		}
+	}
+
+	keys = slices.DeleteFunc(keys, func(key string) bool {
+		return slices.Contains(secretKeys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+// printUsage writes the top-level help
+//
+//nolint:errcheck // Best effort output to the terminal
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [flags] <command> [flags] [args]\n\nCommands:\n", appName)
+	for _, command := range Commands {
+		fmt.Fprintf(w, "  %-12s %s\n", command.Name, command.Summary)
+	}
+	fmt.Fprintf(w, "  %-12s %s\n", "help", "Show the help of a command")
+
+	fmt.Fprintln(w, "\nFlags:")
+	printGlobalFlags(w)
+
+	fmt.Fprintln(w, "\nConfiguration overrides, each sets the key of the same name:")
+	defaults := flagDefaults()
+	for _, key := range overrideKeys() {
+		if isAlias(FlagName(key)) {
+			continue
+		}
+		line := "  --" + FlagName(key)
+		if value, ok := defaults[key]; ok {
+			text := fmt.Sprintf("%v", value)
+			if _, ok := value.(string); ok {
+				text = strconv.Quote(text)
+			}
+			if text != `""` && text != "[]" {
+				line = fmt.Sprintf("%-40s (default %s)", line, text)
+			}
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w, "\nLists take comma-separated values. Run 'bumpa <command> --help' for the flags of a command.")
+}
+
+// printCommandUsage writes the help of command
+//
+//nolint:errcheck // Best effort output to the terminal
+func printCommandUsage(w io.Writer, command *Command) {
+	usage := strings.TrimSpace(fmt.Sprintf("%s %s [flags] %s", appName, command.Name, command.Usage))
+	fmt.Fprintf(w, "Usage: %s\n\n%s\n", usage, command.Summary)
+
+	if len(command.Flags) > 0 {
+		fmt.Fprintln(w, "\nFlags:")
+		for _, commandFlag := range command.Flags {
+			usage := commandFlag.Usage
+			switch {
+			case commandFlag.Open:
+				usage += " (e.g. " + strings.Join(commandFlag.Values, ", ") + ")"
+			case len(commandFlag.Values) > 0:
+				usage += " (" + strings.Join(commandFlag.Values, ", ") + ")"
+			}
+			fmt.Fprintf(w, "  --%-18s %s\n", commandFlag.Name+" "+commandFlag.Arg, usage)
+		}
+	}
+
+	fmt.Fprintln(w, "\nGlobal flags:")
+	printGlobalFlags(w)
+	fmt.Fprintln(w, "\nEvery configuration key can be set with a flag, run 'bumpa --help' to list them.")
+}
+
+//nolint:errcheck // Best effort output to the terminal
+func printGlobalFlags(w io.Writer) {
+	fmt.Fprintf(w, "  --%-18s %s\n", "config path", "Configuration file used instead of .bumpa.yaml")
+	for _, alias := range flagAliases {
+		fmt.Fprintf(w, "  --%-18s %s\n", strings.TrimSpace(alias.name+" "+alias.arg), alias.usage)
+	}
+}
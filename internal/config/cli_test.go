@@ -0,0 +1,47 @@
+package config
+
+import (
+	"testing"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+func TestParseArgsHelp(t *testing.T) {
+	tests := [][]string{
+		{"help"},
+		{"--help"},
+		{"-h"},
+		{"help", "commit"},
+		{"commit", "--help"},
+		{"--config", "other.yaml", "version", "-h"},
+	}
+
+	for _, arguments := range tests {
+		args, err := ParseArgs(arguments)
+		if !errors.Is(err, errors.ErrHelpShown) {
+			t.Errorf("%q: got error %v, want ErrHelpShown", arguments, err)
+		}
+		if args != nil {
+			t.Errorf("%q: got args %+v, want none", arguments, args)
+		}
+	}
+}
+
+func TestParseArgsErrors(t *testing.T) {
+	tests := [][]string{
+		{},
+		{"--no-such-flag", "commit"},
+		{"commit", "--no-such-flag"},
+	}
+
+	for _, arguments := range tests {
+		_, err := ParseArgs(arguments)
+		if err == nil || errors.Is(err, errors.ErrHelpShown) {
+			t.Errorf("%q: got error %v, want an input error", arguments, err)
+			continue
+		}
+		if code := errors.GetCode(err); code != errors.CodeInputError {
+			t.Errorf("%q: got code %s, want %s", arguments, code, errors.CodeInputError)
+		}
+	}
+}
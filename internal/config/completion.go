@@ -0,0 +1,182 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+// Shells with completion support
+var Shells = []string{"bash", "zsh", "fish"}
+
+// completionFlag is a global flag as offered by shell completion
+type completionFlag struct {
+	name   string
+	usage  string
+	valued bool // Takes a value, so the next word is not a command
+}
+
+// completionFlags returns --config, the aliases and the configuration overrides
+func completionFlags() []completionFlag {
+	flags := []completionFlag{{name: "config", usage: "Configuration file used instead of .bumpa.yaml", valued: true}}
+	seen := map[string]bool{"config": true}
+
+	defaults := flagDefaults()
+	for _, alias := range flagAliases {
+		_, isBool := defaults[alias.key].(bool)
+		flags = append(flags, completionFlag{name: alias.name, usage: alias.usage, valued: !isBool})
+		seen[alias.name] = true
+	}
+	for _, key := range overrideKeys() {
+		if name := FlagName(key); !seen[name] {
+			_, isBool := defaults[key].(bool)
+			flags = append(flags, completionFlag{name: name, usage: "Set " + key, valued: !isBool})
+		}
+	}
+
+	return flags
+}
+
+// WriteCompletion writes the completion script for shell
+func WriteCompletion(w io.Writer, shell string) error {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion()
+	case "zsh":
+		// zsh runs the bash function through its compatibility layer
+		script = "#compdef " + appName + "\nautoload -U +X bashcompinit && bashcompinit\n\n" + bashCompletion()
+	case "fish":
+		script = fishCompletion()
+	default:
+		return errors.WrapWithContext(
+			errors.CodeInputError,
+			errors.ErrInvalidInput,
+			"unsupported shell: "+shell+" (expected "+strings.Join(Shells, ", ")+")",
+		)
+	}
+
+	if _, err := io.WriteString(w, script); err != nil {
+		return errors.Wrap(errors.CodeIOError, err)
+	}
+	return nil
+}
+
+func bashCompletion() string {
+	flags := completionFlags()
+	var global, valued []string
+	for _, flag := range flags {
+		global = append(global, "--"+flag.name)
+		if flag.valued {
+			valued = append(valued, "--"+flag.name)
+		}
+	}
+	for _, command := range Commands {
+		for _, flag := range command.Flags {
+			valued = append(valued, "--"+flag.Name)
+		}
+	}
+
+	commands := make([]string, 0, len(Commands)+1)
+	for _, command := range Commands {
+		commands = append(commands, command.Name)
+	}
+	commands = append(commands, "help")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n_%s() {\n", appName, appName)
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(&b, "    local global=%q\n", strings.Join(global, " "))
+	fmt.Fprintf(&b, "    local valued=%q\n", " "+strings.Join(valued, " ")+" ")
+	b.WriteString(`    local command="" skip="" word
+    for word in "${COMP_WORDS[@]:1:COMP_CWORD-1}"; do
+        if [[ -n $skip ]]; then skip=""; continue; fi
+        case "$word" in
+            -*=*) ;;
+            -*) word="${word#--}"; [[ $valued == *" --${word#-} "* ]] && skip=1 ;;
+            *) command="$word"; break ;;
+        esac
+    done
+
+    case "$prev" in
+        --config) COMPREPLY=($(compgen -f -- "$cur")); return ;;
+`)
+	for _, command := range Commands {
+		for _, flag := range command.Flags {
+			if len(flag.Values) > 0 {
+				fmt.Fprintf(&b, "        --%s) COMPREPLY=($(compgen -W %q -- \"$cur\")); return ;;\n",
+					flag.Name, strings.Join(flag.Values, " "))
+			}
+		}
+	}
+	b.WriteString("    esac\n    [[ $valued == *\" $prev \"* ]] && return\n\n    case \"$command\" in\n")
+	fmt.Fprintf(&b, "        \"\") COMPREPLY=($(compgen -W %q\" $global\" -- \"$cur\")) ;;\n", strings.Join(commands, " "))
+	fmt.Fprintf(&b, "        help) COMPREPLY=($(compgen -W %q -- \"$cur\")) ;;\n", strings.Join(commands[:len(Commands)], " "))
+	for _, command := range Commands {
+		words := append([]string{}, command.Actions...)
+		for _, flag := range command.Flags {
+			words = append(words, "--"+flag.Name)
+		}
+		fmt.Fprintf(&b, "        %s) COMPREPLY=($(compgen -W %q\" $global\" -- \"$cur\")) ;;\n",
+			command.Name, strings.Join(words, " "))
+	}
+	b.WriteString("        *) COMPREPLY=($(compgen -W \"$global\" -- \"$cur\")) ;;\n    esac\n}\n")
+	fmt.Fprintf(&b, "complete -F _%s %s\n", appName, appName)
+
+	return b.String()
+}
+
+func fishCompletion() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\ncomplete -c %s -f\n\n", appName, appName)
+
+	for _, command := range Commands {
+		fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a %s -d %s\n",
+			appName, command.Name, fishQuote(command.Summary))
+	}
+	fmt.Fprintf(&b, "complete -c %s -n __fish_use_subcommand -a help -d %s\n",
+		appName, fishQuote("Show the help of a command"))
+
+	names := make([]string, 0, len(Commands))
+	for _, command := range Commands {
+		names = append(names, command.Name)
+	}
+	fmt.Fprintf(&b, "complete -c %s -n '__fish_seen_subcommand_from help' -a %s\n\n",
+		appName, fishQuote(strings.Join(names, " ")))
+
+	for _, command := range Commands {
+		condition := fishQuote("__fish_seen_subcommand_from " + command.Name)
+		if len(command.Actions) > 0 {
+			fmt.Fprintf(&b, "complete -c %s -n %s -a %s\n",
+				appName, condition, fishQuote(strings.Join(command.Actions, " ")))
+		}
+		for _, flag := range command.Flags {
+			fmt.Fprintf(&b, "complete -c %s -n %s -l %s -x", appName, condition, flag.Name)
+			if len(flag.Values) > 0 {
+				fmt.Fprintf(&b, " -a %s", fishQuote(strings.Join(flag.Values, " ")))
+			}
+			fmt.Fprintf(&b, " -d %s\n", fishQuote(flag.Usage))
+		}
+	}
+	b.WriteString("\n")
+
+	for _, flag := range completionFlags() {
+		fmt.Fprintf(&b, "complete -c %s -l %s", appName, flag.name)
+		switch {
+		case flag.name == "config":
+			b.WriteString(" -r -F")
+		case flag.valued:
+			b.WriteString(" -x")
+		}
+		fmt.Fprintf(&b, " -d %s\n", fishQuote(flag.usage))
+	}
+
+	return b.String()
+}
+
+// fishQuote single-quotes s for fish
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -1,11 +1,11 @@
 package config
 
 import (
-	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,13 +16,50 @@ import (
 
 const (
 	DefaultMaxRetries       = 3
-	DefaultMaxDiffLines     = 10
+	DefaultMaxDiffLines     = 0 // Unlimited, diffs are fitted to the model's context window instead
 	DefaultCommitMsgTimeout = 30 * time.Second
 	DefaultRequestTimeout   = 30 * time.Second
 	DefaultLogFilePerms     = os.FileMode(0o666)
 	DefaultLogDirPerms      = os.FileMode(0o755)
 	DefaultPermissionsMask  = os.FileMode(0o777)
 	DefaultLineLength       = 72
+	DefaultConcurrency      = 4
+	DefaultRateLimitRetries = 5
+	DefaultInitialBackoff   = time.Second
+	DefaultMaxBackoff       = time.Minute
+	DefaultCacheTTL         = 7 * 24 * time.Hour
+	DefaultCacheMaxSizeMB   = 50
+	DefaultMaxOutputTokens  = 1024
+	DefaultDirectoryLimit   = 25
+	DefaultRedactEntropy    = 4.0
+	DefaultMinSecretLength  = 20
+
+	// Strategies for file diffs that do not fit the model's context window
+	OverflowTruncate  = "truncate"  // Keep whole hunks up to the budget, drop the rest
+	OverflowSplit     = "split"     // Summarize the diff in chunks of hunks that each fit
+	OverflowSummarize = "summarize" // Summarize chunks of hunks, then reduce them into one summary
+
+	// Network policies for the LLM endpoint
+	ModeAny       = "any"        // Any base_url is allowed
+	ModeLocalOnly = "local-only" // base_url must resolve to loopback or private addresses
+
+	// Sources of commit messages
+	CommitModeLLM      = "llm"      // Generated by the LLM from file summaries
+	CommitModeTemplate = "template" // Rendered from commit.template without an LLM
+
+	// DefaultCommitTemplate renders a Conventional Commits header from the change data, with the
+	// ticket from the branch name as a trailer
+	DefaultCommitTemplate = "{{.Type}}{{with .Scope}}({{.}}){{end}}{{if .Breaking}}!{{end}}: {{.Description}}" +
+		"{{with .Ticket}}\n\nRefs: {{.}}{{end}}"
+
+	// DefaultTicketPattern matches issue keys such as ABC-123 in branch names
+	DefaultTicketPattern = `[A-Z][A-Z0-9]+-[0-9]+`
+
+	// ReduceFunction combines summaries of chunks, files or directories into one
+	ReduceFunction = "reduce_summaries"
+
+	// envPrefix starts every environment variable read by bumpa
+	envPrefix = "BUMPA"
 
 	// Common time formats
 	TimeFormatRFC3339 = "2006-01-02T15:04:05Z07:00"
@@ -30,14 +67,71 @@ const (
 	TimeFormatSimple  = "2006-01-02"
 )
 
+// envMappings maps configuration keys to shorter environment variable names, after the prefix
+var envMappings = map[string]string{
+	"logging.level":       "LOG_LEVEL",
+	"logging.environment": "ENVIRONMENT",
+	"logging.output":      "LOG_OUTPUT",
+	"logging.timeformat":  "LOG_TIMEFORMAT",
+	"logging.file_path":   "LOG_FILE",
+	"llm.api_key":         "LLM_API_KEY",
+	"llm.base_url":        "LLM_BASE_URL",
+	"llm.model":           "LLM_MODEL",
+	"llm.mode":            "LLM_MODE",
+	"llm.record":          "LLM_RECORD",
+	"llm.replay":          "LLM_REPLAY",
+	"offline":             "OFFLINE",
+}
+
+// DefaultNeverSend lists files whose content never leaves the machine
+var DefaultNeverSend = []string{
+	".env", ".env.*", "*.pem", "*.key", "*.p12", "*.pfx", "*.keystore", "id_rsa", "id_ecdsa", "id_ed25519",
+	".netrc", ".npmrc", ".pypirc",
+}
+
 type Config struct {
-	Logging   LoggingConfig `mapstructure:"logging"`
-	Git       GitConfig     `mapstructure:"git"`
-	LLM       LLMConfig     `mapstructure:"llm"`
-	Functions []LLMFunction `mapstructure:"functions"`
-	Command   string        `mapstructure:"command"`
-	Version   VersionConfig `mapstructure:"version"`
-	NoConfirm bool          `mapstructure:"no_confirm"`
+	Logging       LoggingConfig      `mapstructure:"logging"`
+	Git           GitConfig          `mapstructure:"git"`
+	LLM           LLMConfig          `mapstructure:"llm"`
+	Functions     []LLMFunction      `mapstructure:"functions"`
+	Command       string             `mapstructure:"command"`
+	Args          []string           `mapstructure:"-"` // Positional arguments after the command
+	Sources       Sources            `mapstructure:"-"` // Layer each setting came from, for config explain
+	Version       VersionConfig      `mapstructure:"version"`
+	Redact        RedactConfig       `mapstructure:"redact"`
+	Commit        CommitConfig       `mapstructure:"commit"`
+	NoConfirm     bool               `mapstructure:"no_confirm"`
+	Offline       bool               `mapstructure:"offline"` // Use deterministic fallbacks, no LLM or network at all
+	Profile       string             `mapstructure:"profile"` // Selected profile, empty if none applies
+	Profiles      map[string]Profile `mapstructure:"profiles"`
+	Strict        bool               `mapstructure:"strict"`         // Fail on unknown and deprecated keys instead of warning
+	ConfigVersion int                `mapstructure:"config_version"` // Format of the configuration files, see Migrate
+	keyIssues     []keyIssue         // Unknown and deprecated keys of the files
+}
+
+// CommitConfig controls how commit messages are produced
+type CommitConfig struct {
+	Mode          string `mapstructure:"mode"`           // llm or template
+	Template      string `mapstructure:"template"`       // Go template rendered over the change data
+	PostProcess   bool   `mapstructure:"post_process"`   // Render LLM messages through the template as well
+	TicketPattern string `mapstructure:"ticket_pattern"` // The first capture group, or the whole match, is the ticket
+	Type          string `mapstructure:"-"`              // Forced type from commit --type
+	Scope         string `mapstructure:"-"`              // Forced scope from commit --scope
+}
+
+// RedactConfig controls what is stripped from diffs before they are sent to the LLM
+type RedactConfig struct {
+	Enabled         bool            `mapstructure:"enabled"`
+	Entropy         float64         `mapstructure:"entropy"`           // Minimum Shannon entropy of redacted random strings, 0 disables
+	Patterns        []RedactPattern `mapstructure:"patterns"`          // Custom detectors in addition to the built-in ones
+	Allowlist       []string        `mapstructure:"allowlist"`         // Regexes for matches that are never redacted
+	NeverSend       []string        `mapstructure:"never_send"`        // Path globs whose content is never sent, regardless of git.ignore
+	MinSecretLength int             `mapstructure:"min_secret_length"` // Minimum length of strings checked for entropy
+}
+
+type RedactPattern struct {
+	Name  string `mapstructure:"name"`
+	Regex string `mapstructure:"regex"` // The first capture group is redacted if present, the whole match otherwise
 }
 
 type GitConfig struct {
@@ -75,17 +169,84 @@ type EnvironmentConfig struct {
 type LLMConfig struct {
 	Provider         string
 	Model            string
-	BaseURL          string        `mapstructure:"base_url"`
-	APIKey           string        `mapstructure:"api_key,omitempty"` // Make API key optional
-	MaxRetries       int           `mapstructure:"max_retries"`
-	CommitMsgTimeout time.Duration `mapstructure:"commit_msg_timeout"`
-	RequestTimeout   time.Duration `mapstructure:"request_timeout"`
+	Mode             string          `mapstructure:"mode"` // Network policy for base_url, any or local-only
+	BaseURL          string          `mapstructure:"base_url"`
+	APIKey           Secret          `mapstructure:"api_key,omitempty"` // Key or reference such as env:NAME, optional
+	MaxRetries       int             `mapstructure:"max_retries"`
+	CommitMsgTimeout time.Duration   `mapstructure:"commit_msg_timeout"`
+	RequestTimeout   time.Duration   `mapstructure:"request_timeout"`
+	Stream           bool            `mapstructure:"stream"`
+	Concurrency      int             `mapstructure:"concurrency"` // Maximum parallel file summaries
+	Cache            CacheConfig     `mapstructure:"cache"`
+	RateLimit        RateLimitConfig `mapstructure:"rate_limit"`
+	ContextWindow    int             `mapstructure:"context_window"`    // Overrides the built-in model registry, 0 uses it
+	MaxOutputTokens  int             `mapstructure:"max_output_tokens"` // Tokens reserved for the response
+	Overflow         string          `mapstructure:"overflow"`          // truncate, split or summarize
+	DirectoryLimit   int             `mapstructure:"directory_limit"`   // Summaries above which they are reduced per directory, 0 disables
+	Usage            UsageConfig     `mapstructure:"usage"`
+	Record           string          `mapstructure:"record"` // Directory to record request/response fixtures to
+	Replay           string          `mapstructure:"replay"` // Directory to replay fixtures from instead of calling the model
+}
+
+// Secret is a configuration value that is kept out of logs and error messages. It prints as a
+// mask unless empty, Reveal returns the value itself.
+type Secret string
+
+const secretMask = "********"
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return secretMask
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Reveal returns the value, a key or a reference to one
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+type UsageConfig struct {
+	Summary bool         `mapstructure:"summary"` // Print token usage and cost after commit and version
+	Ledger  string       `mapstructure:"ledger"`  // JSONL file each run is appended to, empty disables
+	Pricing []ModelPrice `mapstructure:"pricing"`
+}
+
+// ModelPrice is the price of a model in currency units per million tokens
+type ModelPrice struct {
+	Model      string  `mapstructure:"model"` // Model name or prefix, the longest match wins
+	Prompt     float64 `mapstructure:"prompt"`
+	Completion float64 `mapstructure:"completion"`
+}
+
+type RateLimitConfig struct {
+	RequestsPerMinute int           `mapstructure:"requests_per_minute"` // Client-side limit, 0 disables
+	TokensPerMinute   int           `mapstructure:"tokens_per_minute"`   // Client-side limit, 0 disables
+	MaxRetries        int           `mapstructure:"max_retries"`         // Retries after HTTP 429
+	InitialBackoff    time.Duration `mapstructure:"initial_backoff"`
+	MaxBackoff        time.Duration `mapstructure:"max_backoff"`
+}
+
+type CacheConfig struct {
+	Enabled   bool          `mapstructure:"enabled"`
+	TTL       time.Duration `mapstructure:"ttl"`
+	MaxSizeMB int64         `mapstructure:"max_size_mb"`
+	Functions []string      `mapstructure:"functions"` // Functions whose responses are cached
 }
 
 type LLMFunction struct {
 	Name         string             `mapstructure:"name"          yaml:"name"`
 	Description  string             `mapstructure:"description"   yaml:"description"`
 	Parameters   FunctionParameters `mapstructure:"parameters"    yaml:"parameters"`
+	Output       FunctionParameters `mapstructure:"output"        yaml:"output"`
 	SystemPrompt string             `mapstructure:"system_prompt" yaml:"system_prompt"` //nolint:tagliatelle // Following OpenAI API spec
 	UserPrompt   string             `mapstructure:"user_prompt"   yaml:"user_prompt"`   //nolint:tagliatelle // Following OpenAI API spec
 }
@@ -104,19 +265,21 @@ type Property struct {
 }
 
 type VersionConfig struct {
-	Current    string        `mapstructure:"current"`
 	Git        VersionGit    `mapstructure:"git"`
-	Prerelease []string      `mapstructure:"prerelease"`
+	Prerelease []string      `mapstructure:"prerelease"` // Pre-release channels, least stable first
 	Files      []VersionFile `mapstructure:"files"`
 	Alpha      bool          `mapstructure:"alpha"`
 	Beta       bool          `mapstructure:"beta"`
 	RC         bool          `mapstructure:"rc"`
+	Bump       string        `mapstructure:"-"` // Component from version --bump, skips analysis
+	Pre        string        `mapstructure:"-"` // Release channel from version --pre
 }
 
 type VersionGit struct {
-	Commit  bool `yaml:"commit"`
-	Tag     bool `yaml:"tag"`
-	Signage bool `yaml:"signage"`
+	Commit     bool `yaml:"commit"`
+	Tag        bool `yaml:"tag"`
+	SignCommit bool `mapstructure:"sign_commit" yaml:"sign_commit"`
+	SignTag    bool `mapstructure:"sign_tag"    yaml:"sign_tag"`
 }
 
 type VersionFile struct {
@@ -124,34 +287,52 @@ type VersionFile struct {
 	Replace []string `yaml:"replace"`
 }
 
-func Load() (*Config, error) {
+// Load reads the configuration layers and applies the command line flags of args on top
+func Load(args *Args) (*Config, error) {
+	cfg, err := load(args)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeys(cfg); err != nil {
+		return nil, err
+	}
+
+	// Validate configuration
+	if err := validateConfig(cfg); err != nil {
+		logger.Error().
+			Err(err).
+			Msg("Configuration validation failed")
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// load reads the configuration like Load, without validating it
+func load(args *Args) (*Config, error) {
 	viper.Reset()
 
 	// Enable environment variables first
 	viper.AutomaticEnv()
-	viper.SetEnvPrefix("BUMPA")
+	viper.SetEnvPrefix(envPrefix)
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
-	// Set config file settings
-	viper.SetConfigName(".bumpa")
-	viper.SetConfigType("yaml")
-	viper.AddConfigPath(".")
-
 	SetDefaults()
+	args.apply(viper.GetViper())
 
-	if err := viper.ReadInConfig(); err != nil {
-		var configFileNotFound viper.ConfigFileNotFoundError
-		if errors.As(err, &configFileNotFound) {
-			logger.Warn().Msg(errors.ContextConfigNotFound)
-		} else {
-			return nil, errors.WrapWithContext(
-				errors.CodeConfigError,
-				err,
-				errors.ContextConfigNotFound,
-			)
-		}
+	// System, user and repository files, each overriding the previous ones
+	layers, err := readLayers(viper.GetViper(), args.ConfigFile)
+	if err != nil {
+		return nil, err
 	}
 
+	// The selected profile overrides the files, the environment and flags still override it
+	profile, err := selectProfile(viper.GetViper())
+	if err != nil {
+		return nil, err
+	}
+	layers = append(layers, profile...)
+
 	var cfg Config
 	if err := viper.Unmarshal(&cfg); err != nil {
 		logger.Error().
@@ -164,28 +345,32 @@ func Load() (*Config, error) {
 		)
 	}
 
-	// Validate configuration
-	if err := validateConfig(&cfg); err != nil {
-		logger.Error().
-			Err(err).
-			Msg("Configuration validation failed")
+	defaults, err := DefaultFunctions()
+	if err != nil {
 		return nil, err
 	}
-
-	// Parse command line flags last to override file config
-	if err := ParseFlags(&cfg); err != nil {
+	cfg.Functions, err = layerFunctions(defaults, layers)
+	if err != nil {
 		return nil, err
 	}
+	cfg.Sources = collectSources(layers)
+	for _, layer := range layers {
+		cfg.keyIssues = append(cfg.keyIssues, layer.issues...)
+	}
+	args.applyOptions(&cfg)
+
+	applyOutputDefaults(cfg.Functions)
 
 	return &cfg, nil
 }
 
-func LoadInitialLogging() (*LoggingConfig, error) {
+// LoadInitialLogging reads the logging configuration needed before the full configuration
+func LoadInitialLogging(args *Args) (*LoggingConfig, error) {
 	viper.Reset()
 
 	// Enable environment variables
 	viper.AutomaticEnv()
-	viper.SetEnvPrefix("BUMPA")
+	viper.SetEnvPrefix(envPrefix)
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 
 	// Bind logging-related environment variables
@@ -207,6 +392,7 @@ func LoadInitialLogging() (*LoggingConfig, error) {
 	}
 
 	SetDefaults()
+	args.apply(viper.GetViper())
 
 	// Validate log level
 	logLevel := viper.GetString("logging.level")
@@ -218,23 +404,8 @@ func LoadInitialLogging() (*LoggingConfig, error) {
 		)
 	}
 
-	if err := viper.ReadInConfig(); err != nil {
-		var configFileNotFound viper.ConfigFileNotFoundError
-		if errors.As(err, &configFileNotFound) {
-			return &LoggingConfig{
-				Environment: viper.GetString("logging.environment"),
-				TimeFormat:  viper.GetString("logging.timeformat"),
-				Output:      viper.GetString("logging.output"),
-				Level:       viper.GetString("logging.level"),
-				FilePerms:   DefaultLogFilePerms,
-				DirPerms:    DefaultLogDirPerms,
-			}, nil
-		}
-		return nil, errors.WrapWithContext(
-			errors.CodeConfigError,
-			err,
-			errors.ContextConfigNotFound,
-		)
+	if _, err := readLayers(viper.GetViper(), args.ConfigFile); err != nil {
+		return nil, err
 	}
 
 	var cfg struct {
@@ -285,6 +456,25 @@ func validateConfig(cfg *Config) error {
 				errors.FormatContext(errors.ContextMissingPrompt, "user", cfg.Functions[i].Name),
 			)
 		}
+		if len(cfg.Functions[i].Output.Properties) == 0 {
+			return errors.WrapWithContext(
+				errors.CodeConfigError,
+				errors.ErrInvalidInput,
+				errors.FormatContext(errors.ContextMissingOutput, cfg.Functions[i].Name),
+			)
+		}
+	}
+
+	if cfg.LLM.Overflow == OverflowSummarize && FindFunction(cfg.Functions, ReduceFunction) == nil {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidInput,
+			errors.FormatContext(errors.ContextMissingReduceFunction, ReduceFunction),
+		)
+	}
+
+	if err := cfg.Version.Validate(); err != nil {
+		return err
 	}
 
 	// Validate required functions exist
@@ -398,6 +588,31 @@ func (c *LoggingConfig) ActiveEnvironment() *EnvironmentConfig {
 	}
 }
 
+// Channel returns the release channel requested by --pre, --alpha, --beta or --rc, or an
+// empty string for a stable release
+func (v *VersionConfig) Channel() string {
+	switch {
+	case v.Pre != "":
+		return v.Pre
+	case v.Alpha:
+		return ChannelAlpha
+	case v.Beta:
+		return ChannelBeta
+	case v.RC:
+		return ChannelRC
+	default:
+		return ""
+	}
+}
+
+// Channels returns the pre-release channels in the order they progress
+func (v *VersionConfig) Channels() []string {
+	if len(v.Prerelease) > 0 {
+		return v.Prerelease
+	}
+	return DefaultChannels
+}
+
 func (v *VersionConfig) Validate() error {
 	// Check that only one pre-release type is set
 	preReleaseCount := 0
@@ -430,91 +645,86 @@ func (v *VersionConfig) Validate() error {
 		}
 	}
 
-	return nil
-}
-
-func ParseFlags(cfg *Config) error {
-	flagSet := flag.NewFlagSet("bumpa", flag.ExitOnError)
-
-	// Version flags
-	alpha := flagSet.Bool("alpha", false, "Mark as alpha release")
-	beta := flagSet.Bool("beta", false, "Mark as beta release")
-	rc := flagSet.Bool("rc", false, "Mark as release candidate")
-	noConfirm := flagSet.Bool("no-confirm", false, "Skip confirmation prompts")
-
-	if err := flagSet.Parse(os.Args[1:]); err != nil {
-		return errors.Wrap(errors.CodeInputError, err)
-	}
-
-	// Handle version flags
-	if *alpha && *beta || *alpha && *rc || *beta && *rc {
-		return errors.WrapWithContext(
-			errors.CodeInputError,
-			errors.ErrInvalidInput,
-			"only one of -alpha, -beta, or -rc can be specified",
-		)
-	}
-
-	// Get command from first non-flag argument
-	if flagSet.NArg() > 0 {
-		cfg.Command = flagSet.Arg(0)
-	}
-
-	if cfg.Command == "" {
+	if channel := v.Channel(); channel != "" && !slices.Contains(v.Channels(), channel) {
 		return errors.WrapWithContext(
-			errors.CodeInputError,
+			errors.CodeConfigError,
 			errors.ErrInvalidInput,
-			errors.ContextNoCommand,
+			errors.FormatContext(errors.ContextVersionChannel, channel, strings.Join(v.Channels(), ", ")),
 		)
 	}
 
-	cfg.Version.Alpha = *alpha
-	cfg.Version.Beta = *beta
-	cfg.Version.RC = *rc
-	cfg.NoConfirm = *noConfirm
-
 	return nil
 }
 
+// SetDefaults sets the built-in defaults and environment variable bindings on the global
+// viper instance
 func SetDefaults() {
-	viper.SetDefault("llm.provider", "openai-compatible")
-	viper.SetDefault("llm.model", "llama3.1:latest")
-	viper.SetDefault("llm.base_url", "http://localhost:11434/v1")
-	viper.SetDefault("llm.api_key", "")
-	viper.SetDefault("llm.max_retries", DefaultMaxRetries)
-	viper.SetDefault("llm.commit_msg_timeout", DefaultCommitMsgTimeout)
-	viper.SetDefault("llm.request_timeout", DefaultRequestTimeout)
-	viper.SetDefault("logging.environment", "development")
-	viper.SetDefault("logging.timeformat", TimeFormatRFC3339)
-	viper.SetDefault("logging.output", "console")
-	viper.SetDefault("logging.level", "info")
-	viper.SetDefault("logging.file_perms", int(DefaultLogFilePerms))
-	viper.SetDefault("logging.dir_perms", int(DefaultLogDirPerms))
-	viper.SetDefault("git.include_gitignore", true)
-	viper.SetDefault("git.max_diff_lines", DefaultMaxDiffLines)
-	viper.SetDefault("git.preferred_line_length", DefaultLineLength)
+	setDefaults(viper.GetViper())
+	bindEnv(viper.GetViper())
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("llm.provider", "openai-compatible")
+	v.SetDefault("llm.model", "llama3.1:latest")
+	v.SetDefault("llm.mode", ModeAny)
+	v.SetDefault("llm.base_url", "http://localhost:11434/v1")
+	v.SetDefault("llm.api_key", "")
+	v.SetDefault("llm.max_retries", DefaultMaxRetries)
+	v.SetDefault("llm.commit_msg_timeout", DefaultCommitMsgTimeout)
+	v.SetDefault("llm.request_timeout", DefaultRequestTimeout)
+	v.SetDefault("llm.stream", false)
+	v.SetDefault("llm.concurrency", DefaultConcurrency)
+	v.SetDefault("llm.context_window", 0)
+	v.SetDefault("llm.max_output_tokens", DefaultMaxOutputTokens)
+	v.SetDefault("llm.overflow", OverflowTruncate)
+	v.SetDefault("llm.directory_limit", DefaultDirectoryLimit)
+	v.SetDefault("llm.usage.summary", true)
+	v.SetDefault("llm.usage.ledger", "")
+	v.SetDefault("llm.rate_limit.requests_per_minute", 0)
+	v.SetDefault("llm.rate_limit.tokens_per_minute", 0)
+	v.SetDefault("llm.rate_limit.max_retries", DefaultRateLimitRetries)
+	v.SetDefault("llm.rate_limit.initial_backoff", DefaultInitialBackoff)
+	v.SetDefault("llm.rate_limit.max_backoff", DefaultMaxBackoff)
+	v.SetDefault("llm.cache.enabled", true)
+	v.SetDefault("llm.cache.ttl", DefaultCacheTTL)
+	v.SetDefault("llm.cache.max_size_mb", DefaultCacheMaxSizeMB)
+	v.SetDefault("llm.cache.functions", []string{"generate_file_summary"})
+	v.SetDefault("profile", "")
+	v.SetDefault("strict", false)
+	v.SetDefault("logging.environment", "development")
+	v.SetDefault("logging.timeformat", TimeFormatRFC3339)
+	v.SetDefault("logging.output", "console")
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.file_perms", int(DefaultLogFilePerms))
+	v.SetDefault("logging.dir_perms", int(DefaultLogDirPerms))
+	v.SetDefault("git.include_gitignore", true)
+	v.SetDefault("git.ignore", []string{})
+	v.SetDefault("git.max_diff_lines", DefaultMaxDiffLines)
+	v.SetDefault("git.preferred_line_length", DefaultLineLength)
 
 	// Add defaults for version config
-	viper.SetDefault("version.current", "0.1.0")
-	viper.SetDefault("version.alpha", false)
-	viper.SetDefault("version.beta", false)
-	viper.SetDefault("version.rc", false)
-	viper.SetDefault("no_confirm", false)
-
-	// Add environment variable mappings
-	envMappings := map[string]string{
-		"logging.level":       "LOG_LEVEL",
-		"logging.environment": "ENVIRONMENT",
-		"logging.output":      "LOG_OUTPUT",
-		"logging.timeformat":  "LOG_TIMEFORMAT",
-		"logging.file_path":   "LOG_FILE",
-		"llm.api_key":         "LLM_API_KEY",
-		"llm.base_url":        "LLM_BASE_URL",
-		"llm.model":           "LLM_MODEL",
-	}
+	v.SetDefault("version.alpha", false)
+	v.SetDefault("version.beta", false)
+	v.SetDefault("version.rc", false)
+	v.SetDefault("no_confirm", false)
+	v.SetDefault("offline", false)
+
+	v.SetDefault("commit.mode", CommitModeLLM)
+	v.SetDefault("commit.template", DefaultCommitTemplate)
+	v.SetDefault("commit.post_process", false)
+	v.SetDefault("commit.ticket_pattern", DefaultTicketPattern)
+
+	v.SetDefault("redact.enabled", true)
+	v.SetDefault("redact.entropy", DefaultRedactEntropy)
+	v.SetDefault("redact.min_secret_length", DefaultMinSecretLength)
+	v.SetDefault("redact.never_send", DefaultNeverSend)
+}
 
+// bindEnv binds the environment variables of envMappings, which take precedence over the
+// automatic BUMPA_<SECTION>_<KEY> names
+func bindEnv(v *viper.Viper) {
 	for configKey, envKey := range envMappings {
-		if err := viper.BindEnv(configKey, "BUMPA_"+envKey); err != nil {
+		if err := v.BindEnv(configKey, envPrefix+"_"+envKey); err != nil {
 			logger.Warn().
 				Str("config_key", configKey).
 				Str("env_key", envKey).
@@ -547,10 +757,10 @@ func isValidLogLevel(level string) bool {
 	}
 }
 
-// isValidPrerelease checks if a prerelease identifier is valid according to semver
+// isValidPrerelease checks if a pre-release channel is a valid semver identifier that starts
+// and ends with a letter, as the number of the release is appended to it
 func isValidPrerelease(pre string) bool {
-	// Simple validation for now: alphanumeric and hyphen only
-	return regexp.MustCompile(`^[0-9A-Za-z-]+$`).MatchString(pre)
+	return regexp.MustCompile(`^[A-Za-z]([0-9A-Za-z-]*[A-Za-z-])?$`).MatchString(pre)
 }
 
 func FindFunction(functions []LLMFunction, name string) *LLMFunction {
@@ -562,6 +772,66 @@ func FindFunction(functions []LLMFunction, name string) *LLMFunction {
 	return nil
 }
 
+// defaultOutputs holds the tool-call schema the model must fill in for each built-in
+// function when the configuration does not declare an output section
+var defaultOutputs = map[string]FunctionParameters{
+	"generate_file_summary": {
+		Type: "object",
+		Properties: map[string]Property{
+			"summary": {Type: "string", Description: "Concise summary of the file changes"},
+		},
+		Required: []string{"summary"},
+	},
+	"generate_commit_message": {
+		Type: "object",
+		Properties: map[string]Property{
+			"message": {Type: "string", Description: "Conventional commit message header"},
+		},
+		Required: []string{"message"},
+	},
+	"retry_commit_message": {
+		Type: "object",
+		Properties: map[string]Property{
+			"message": {Type: "string", Description: "Conventional commit message header"},
+		},
+		Required: []string{"message"},
+	},
+	"analyze_version_bump": {
+		Type: "object",
+		Properties: map[string]Property{
+			"bump_type": {
+				Type:        "string",
+				Description: "Semantic version component to increment",
+				Enum:        []string{"major", "minor", "patch", "none"},
+			},
+			"pre_release": {
+				Type:        "string",
+				Description: "Pre-release stage with number (e.g. alpha1, beta2, rc1), empty for stable",
+			},
+		},
+		Required: []string{"bump_type"},
+	},
+	ReduceFunction: {
+		Type: "object",
+		Properties: map[string]Property{
+			"summary": {Type: "string", Description: "Combined summary of all changes in scope"},
+		},
+		Required: []string{"summary"},
+	},
+}
+
+// applyOutputDefaults fills in the output schema of built-in functions that don't declare one
+func applyOutputDefaults(functions []LLMFunction) {
+	for i := range functions {
+		if len(functions[i].Output.Properties) > 0 {
+			continue
+		}
+		if output, ok := defaultOutputs[functions[i].Name]; ok {
+			functions[i].Output = output
+		}
+	}
+}
+
 func hasRequiredFunctions(functions []LLMFunction) bool {
 	required := map[string]bool{
 		"generate_file_summary":   false,
@@ -0,0 +1,71 @@
+package config
+
+import (
+	_ "embed"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultFunctionsYAML holds the built-in function definitions, used for every function a
+// config doesn't define itself
+//
+//go:embed functions.yaml
+var defaultFunctionsYAML []byte
+
+// DefaultFunctionsYAML returns the built-in function definitions as shipped, comments included
+func DefaultFunctionsYAML() []byte {
+	return defaultFunctionsYAML
+}
+
+// DefaultFunctions returns the built-in function definitions
+func DefaultFunctions() ([]LLMFunction, error) {
+	var defaults struct {
+		Functions []LLMFunction `yaml:"functions"`
+	}
+	if err := yaml.Unmarshal(defaultFunctionsYAML, &defaults); err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			"failed to parse built-in functions",
+		)
+	}
+	return defaults.Functions, nil
+}
+
+// mergeFunctions overlays overrides onto defaults by function name. Fields set in an override
+// replace the default ones, parameters and output schemas as a whole, and functions without a
+// default are appended.
+func mergeFunctions(defaults, overrides []LLMFunction) []LLMFunction {
+	merged := append([]LLMFunction(nil), defaults...)
+	for i := range overrides {
+		if fn := FindFunction(merged, overrides[i].Name); fn != nil {
+			fn.merge(&overrides[i])
+			continue
+		}
+		merged = append(merged, overrides[i])
+	}
+	return merged
+}
+
+func (f *LLMFunction) merge(override *LLMFunction) {
+	if override.Description != "" {
+		f.Description = override.Description
+	}
+	if override.Parameters.isSet() {
+		f.Parameters = override.Parameters
+	}
+	if override.Output.isSet() {
+		f.Output = override.Output
+	}
+	if override.SystemPrompt != "" {
+		f.SystemPrompt = override.SystemPrompt
+	}
+	if override.UserPrompt != "" {
+		f.UserPrompt = override.UserPrompt
+	}
+}
+
+func (p *FunctionParameters) isSet() bool {
+	return p.Type != "" || len(p.Properties) > 0 || len(p.Required) > 0
+}
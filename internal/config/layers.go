@@ -0,0 +1,273 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/spf13/viper"
+)
+
+// Configuration layers, lowest precedence first
+const (
+	LayerDefault = "default"
+	LayerSystem  = "system"
+	LayerUser    = "user"
+	LayerRepo    = "repo"
+	LayerProfile = "profile"
+	LayerEnv     = "env"
+	LayerFlag    = "flag"
+
+	SystemConfigPath = "/etc/bumpa/config.yaml"
+	userConfigFile   = "config.yaml"
+	appName          = "bumpa"
+)
+
+// RepoConfigFiles are looked up in the repository root, the first existing one is used
+var RepoConfigFiles = []string{".bumpa.yaml", ".bumpa.yml"}
+
+// Origin is the value one layer sets for a key
+type Origin struct {
+	Layer  string      // default, system, user, repo, profile, env or flag
+	Source string      // File, environment variable or flag the value was read from
+	Value  interface{} // Value as read, before decoding into Config
+}
+
+// Sources records the origins of every configuration key, lowest precedence first. The last
+// origin of a key is the one in effect.
+type Sources map[string][]Origin
+
+func (s Sources) add(key string, origin Origin) {
+	s[key] = append(s[key], origin)
+}
+
+// Explanation lists the origins of one key
+type Explanation struct {
+	Key     string
+	Origins []Origin
+}
+
+// Explain returns the origins of key, or of every key below it if key names a section,
+// sorted by key
+func (s Sources) Explain(key string) []Explanation {
+	key = strings.ToLower(key)
+	var explanations []Explanation
+	for k, origins := range s {
+		if k == key || strings.HasPrefix(k, key+".") {
+			explanations = append(explanations, Explanation{Key: k, Origins: origins})
+		}
+	}
+	sort.Slice(explanations, func(i, j int) bool {
+		return explanations[i].Key < explanations[j].Key
+	})
+	return explanations
+}
+
+// fileLayer is a configuration file read into its own viper instance
+type fileLayer struct {
+	name   string
+	path   string
+	viper  *viper.Viper
+	issues []keyIssue // Unknown and deprecated keys
+}
+
+// layerPath is the candidate configuration file of a layer
+type layerPath struct {
+	name string
+	path string
+}
+
+// configPaths returns the candidate file of each file layer, lowest precedence first. A
+// configFile given with --config replaces the repository file.
+func configPaths(configFile string) []layerPath {
+	paths := []layerPath{{LayerSystem, SystemConfigPath}}
+
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = filepath.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		paths = append(paths, layerPath{LayerUser, filepath.Join(configHome, appName, userConfigFile)})
+	}
+
+	if configFile != "" {
+		return append(paths, layerPath{LayerRepo, configFile})
+	}
+
+	root := RepoRoot()
+	for _, name := range RepoConfigFiles {
+		path := filepath.Join(root, name)
+		if _, err := os.Stat(path); err == nil {
+			return append(paths, layerPath{LayerRepo, path})
+		}
+	}
+	return append(paths, layerPath{LayerRepo, filepath.Join(root, RepoConfigFiles[0])})
+}
+
+// RepoRoot returns the root of the git worktree containing the working directory, or the
+// working directory itself outside of a repository
+func RepoRoot() string {
+	repo, err := gogit.PlainOpenWithOptions(".", &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return "."
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "."
+	}
+	return worktree.Filesystem.Root()
+}
+
+// readLayers reads the system, user and repository configuration files that exist and merges
+// them into v in order of precedence. A configFile given explicitly must exist.
+func readLayers(v *viper.Viper, configFile string) ([]fileLayer, error) {
+	var layers []fileLayer
+	for _, candidate := range configPaths(configFile) {
+		name, path := candidate.name, candidate.path
+		if _, err := os.Stat(path); err != nil {
+			if path == configFile {
+				return nil, errors.WrapWithContext(
+					errors.CodeConfigError,
+					err,
+					errors.FormatContext(errors.ContextFileRead, path),
+				)
+			}
+			logger.Debug().
+				Str("layer", name).
+				Str("path", path).
+				Msg("No configuration file for layer")
+			continue
+		}
+
+		layer := viper.New()
+		layer.SetConfigFile(path)
+		layer.SetConfigType("yaml")
+		if err := layer.ReadInConfig(); err != nil {
+			return nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextFileRead, path),
+			)
+		}
+
+		// Deprecated keys are read as their replacements
+		settings := layer.AllSettings()
+		issues := upgradeLayer(path, settings)
+		layer = viper.New()
+		if err := layer.MergeConfigMap(settings); err != nil {
+			return nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextFileRead, path),
+			)
+		}
+
+		// Nested sections are merged key by key, lists replace those of lower layers
+		if err := v.MergeConfigMap(layer.AllSettings()); err != nil {
+			return nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextFileRead, path),
+			)
+		}
+
+		logger.Debug().
+			Str("layer", name).
+			Str("path", path).
+			Msg("Configuration layer loaded")
+
+		layers = append(layers, fileLayer{name: name, path: path, viper: layer, issues: issues})
+	}
+
+	// The built-in defaults are a complete configuration, no file is required
+	if len(layers) == 0 {
+		logger.Debug().Msg(errors.ContextConfigNotFound)
+	}
+
+	return layers, nil
+}
+
+// layerFunctions merges the functions of every layer onto the built-in ones by name, so a
+// repository can override one prompt of a function defined in the user configuration
+func layerFunctions(defaults []LLMFunction, layers []fileLayer) ([]LLMFunction, error) {
+	functions := defaults
+	for _, layer := range layers {
+		var overrides []LLMFunction
+		if err := layer.viper.UnmarshalKey("functions", &overrides); err != nil {
+			return nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.ContextConfigUnmarshal,
+			)
+		}
+		functions = mergeFunctions(functions, overrides)
+	}
+	return functions, nil
+}
+
+// collectSources records the value every layer sets for each key
+func collectSources(layers []fileLayer) Sources {
+	sources := make(Sources)
+
+	defaults := viper.New()
+	setDefaults(defaults)
+	for key, value := range flatten("", defaults.AllSettings()) {
+		sources.add(key, Origin{Layer: LayerDefault, Value: value})
+	}
+
+	for _, layer := range layers {
+		for key, value := range flatten("", layer.viper.AllSettings()) {
+			sources.add(key, Origin{Layer: layer.name, Source: layer.path, Value: value})
+		}
+	}
+
+	keys := make([]string, 0, len(sources)+len(envMappings))
+	for key := range sources {
+		keys = append(keys, key)
+	}
+	for key := range envMappings {
+		if _, ok := sources[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		name := envName(key)
+		if value, ok := os.LookupEnv(name); ok {
+			sources.add(key, Origin{Layer: LayerEnv, Source: name, Value: value})
+		}
+	}
+
+	return sources
+}
+
+// envName returns the environment variable that overrides key
+func envName(key string) string {
+	if alias, ok := envMappings[key]; ok {
+		return envPrefix + "_" + alias
+	}
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// flatten turns nested settings into dotted keys, lists and scalars are leaves
+func flatten(prefix string, settings map[string]interface{}) map[string]interface{} {
+	flat := make(map[string]interface{})
+	for key, value := range settings {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		if nested, ok := value.(map[string]interface{}); ok && len(nested) > 0 {
+			for k, v := range flatten(key, nested) {
+				flat[k] = v
+			}
+			continue
+		}
+		flat[key] = value
+	}
+	return flat
+}
@@ -0,0 +1,466 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentConfigVersion is the config_version of the format this build reads. Files without a
+// config_version are version 1.
+const CurrentConfigVersion = 2
+
+// yamlIndent is the indentation of migrated files, as in bumpa.example.yaml
+const yamlIndent = 2
+
+// Deprecation is a key an older config_version used. Its value moves to the replacements,
+// unless a replacement is set in the same file already.
+type Deprecation struct {
+	Key          string
+	Replacements []string // Keys taking over the value, none if the setting was dropped
+	Since        int      // config_version that deprecated the key
+	Reason       string   // Why the key was dropped, for keys without replacements
+}
+
+// Deprecations lists the keys of older config_versions, which are still read but reported
+var Deprecations = []Deprecation{
+	{
+		Key:          "version.git.signage",
+		Replacements: []string{"version.git.sign_commit", "version.git.sign_tag"},
+		Since:        2,
+	},
+	{
+		Key:    "version.current",
+		Since:  2,
+		Reason: "the current version is read from the latest tag",
+	},
+}
+
+// keyIssue is an unknown or deprecated key in a configuration file
+type keyIssue struct {
+	source  string
+	key     string
+	message string
+}
+
+// describe explains what replaced a deprecated key
+func (d *Deprecation) describe() string {
+	if len(d.Replacements) == 0 {
+		return "removed, " + d.Reason
+	}
+	return "replaced by " + strings.Join(d.Replacements, " and ")
+}
+
+// checkKeys reports the unknown and deprecated keys of the configuration files, failing on
+// them if strict is set
+func checkKeys(cfg *Config) error {
+	for _, issue := range cfg.keyIssues {
+		logger.Warn().
+			Str("file", issue.source).
+			Str("key", issue.key).
+			Msg(issue.message)
+	}
+
+	if cfg.Strict && len(cfg.keyIssues) > 0 {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextConfigStrict, len(cfg.keyIssues)),
+		)
+	}
+	return nil
+}
+
+// upgradeLayer moves the deprecated keys of a configuration file to their replacements and
+// returns the issues with its keys
+func upgradeLayer(path string, settings map[string]interface{}) []keyIssue {
+	var issues []keyIssue
+
+	if value, ok := settings["config_version"]; ok {
+		if version, err := strconv.Atoi(fmt.Sprint(value)); err == nil && version > CurrentConfigVersion {
+			issues = append(issues, keyIssue{
+				source: path,
+				key:    "config_version",
+				message: "written for config_version " + strconv.Itoa(version) +
+					", this bumpa reads up to " + strconv.Itoa(CurrentConfigVersion) + ", upgrade bumpa",
+			})
+		}
+	}
+
+	for _, root := range settingsRoots(settings) {
+		for i := range Deprecations {
+			deprecation := &Deprecations[i]
+			value, ok := lookupSetting(root.settings, deprecation.Key)
+			if !ok {
+				continue
+			}
+			deleteSetting(root.settings, deprecation.Key)
+			for _, replacement := range deprecation.Replacements {
+				if _, set := lookupSetting(root.settings, replacement); !set {
+					storeSetting(root.settings, replacement, value)
+				}
+			}
+			issues = append(issues, keyIssue{
+				source: path,
+				key:    root.prefix + deprecation.Key,
+				message: "deprecated since config_version " + strconv.Itoa(deprecation.Since) + ", " +
+					deprecation.describe() + ", run bumpa config migrate",
+			})
+		}
+	}
+
+	for _, key := range unknownKeys(reflect.TypeOf(Config{}), "", settings) {
+		issues = append(issues, keyIssue{source: path, key: key, message: "unknown key, it is ignored"})
+	}
+
+	return issues
+}
+
+// settingsRoot is a mapping that holds top-level sections, the file itself or a profile
+type settingsRoot struct {
+	prefix   string
+	settings map[string]interface{}
+}
+
+// settingsRoots returns the file settings and the settings of each profile
+func settingsRoots(settings map[string]interface{}) []settingsRoot {
+	roots := []settingsRoot{{settings: settings}}
+	profiles, _ := settings["profiles"].(map[string]interface{})
+	for _, name := range sortedKeys(profiles) {
+		if profile, ok := profiles[name].(map[string]interface{}); ok {
+			roots = append(roots, settingsRoot{prefix: "profiles." + name + ".", settings: profile})
+		}
+	}
+	return roots
+}
+
+// lookupSetting returns the value at a dotted key of nested settings
+func lookupSetting(settings map[string]interface{}, key string) (interface{}, bool) {
+	path := strings.Split(key, ".")
+	for _, segment := range path[:len(path)-1] {
+		nested, ok := settings[segment].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		settings = nested
+	}
+	value, ok := settings[path[len(path)-1]]
+	return value, ok
+}
+
+// storeSetting sets the value at a dotted key of nested settings, creating the sections on
+// the way
+func storeSetting(settings map[string]interface{}, key string, value interface{}) {
+	path := strings.Split(key, ".")
+	for _, segment := range path[:len(path)-1] {
+		nested, ok := settings[segment].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			settings[segment] = nested
+		}
+		settings = nested
+	}
+	settings[path[len(path)-1]] = value
+}
+
+// deleteSetting removes the value at a dotted key of nested settings
+func deleteSetting(settings map[string]interface{}, key string) {
+	path := strings.Split(key, ".")
+	for _, segment := range path[:len(path)-1] {
+		nested, ok := settings[segment].(map[string]interface{})
+		if !ok {
+			return
+		}
+		settings = nested
+	}
+	delete(settings, path[len(path)-1])
+}
+
+// unknownKeys returns the keys of value that no field of type t reads, as dotted paths
+func unknownKeys(t reflect.Type, prefix string, value interface{}) []string {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	var unknown []string
+	switch t.Kind() {
+	case reflect.Ptr:
+		return unknownKeys(t.Elem(), prefix, value)
+	case reflect.Struct:
+		settings, ok := value.(map[string]interface{})
+		if !ok {
+			return nil // Values of the wrong type are reported by validate
+		}
+		fields := make(map[string]reflect.Type)
+		for i := 0; i < t.NumField(); i++ {
+			if name := fieldKey(t.Field(i)); name != "" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+		for _, key := range sortedKeys(settings) {
+			fieldType, ok := fields[strings.ToLower(key)]
+			if !ok {
+				unknown = append(unknown, join(key))
+				continue
+			}
+			unknown = append(unknown, unknownKeys(fieldType, join(key), settings[key])...)
+		}
+	case reflect.Slice:
+		items, _ := value.([]interface{})
+		for i, item := range items {
+			unknown = append(unknown, unknownKeys(t.Elem(), join(strconv.Itoa(i)), item)...)
+		}
+	case reflect.Map:
+		settings, _ := value.(map[string]interface{})
+		for _, key := range sortedKeys(settings) {
+			unknown = append(unknown, unknownKeys(t.Elem(), join(key), settings[key])...)
+		}
+	}
+	return unknown
+}
+
+// Migrate rewrites a configuration file to the current config_version, editing the parsed
+// document so comments and the order of keys are kept. It returns the new content and a
+// description of every change, none if the file is current.
+func Migrate(content []byte) ([]byte, []string, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return nil, nil, errors.Wrap(errors.CodeConfigError, err)
+	}
+	root := documentRoot(&document)
+	if root == nil {
+		root = &yaml.Node{Kind: yaml.MappingNode}
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{root}}
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.ContextConfigNotMapping,
+		)
+	}
+
+	version := 1
+	if _, value := mappingEntry(root, "config_version"); value != nil {
+		var err error
+		if version, err = strconv.Atoi(value.Value); err != nil {
+			return nil, nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				errors.ErrInvalidConfig,
+				errors.FormatContext(errors.ContextConfigVersion, value.Value),
+			)
+		}
+	}
+	if version > CurrentConfigVersion {
+		return nil, nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextConfigNewer, version, CurrentConfigVersion),
+		)
+	}
+
+	var changes []string
+	for _, node := range nodeRoots(root) {
+		for i := range Deprecations {
+			if migrateNode(node.mapping, &Deprecations[i]) {
+				changes = append(changes, node.prefix+Deprecations[i].Key+": "+Deprecations[i].describe())
+			}
+		}
+	}
+
+	if version < CurrentConfigVersion {
+		setConfigVersion(root)
+		changes = append(changes, "config_version: set to "+strconv.Itoa(CurrentConfigVersion))
+	}
+	if len(changes) == 0 {
+		return content, nil, nil
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(yamlIndent)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, nil, errors.Wrap(errors.CodeConfigError, err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, nil, errors.Wrap(errors.CodeConfigError, err)
+	}
+	if bytes.Contains(content, []byte("\n\n")) {
+		return spaceSections(buf.Bytes()), changes, nil
+	}
+	return buf.Bytes(), changes, nil
+}
+
+// spaceSections puts an empty line between top-level sections, which yaml.v3 does not keep.
+// A line starts a section if it is not indented and follows an indented one.
+func spaceSections(content []byte) []byte {
+	lines := bytes.SplitAfter(content, []byte("\n"))
+	var buf bytes.Buffer
+	for i, line := range lines {
+		if i > 0 && len(line) > 0 && !isIndented(line) && isIndented(lines[i-1]) {
+			buf.WriteByte('\n')
+		}
+		buf.Write(line)
+	}
+	return buf.Bytes()
+}
+
+// isIndented reports whether a line of YAML belongs to a nested section or list
+func isIndented(line []byte) bool {
+	return bytes.HasPrefix(line, []byte(" ")) || bytes.HasPrefix(line, []byte("-"))
+}
+
+// MigrateFile rewrites the configuration file at path to the current config_version, keeping
+// its permissions. It returns the changes, none if the file was current.
+func MigrateFile(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			errors.FormatContext(errors.ContextFileRead, path),
+		)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			errors.FormatContext(errors.ContextFileRead, path),
+		)
+	}
+
+	migrated, changes, err := Migrate(content)
+	if err != nil || len(changes) == 0 {
+		return nil, err
+	}
+
+	if err := os.WriteFile(path, migrated, info.Mode().Perm()); err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, path),
+		)
+	}
+	return changes, nil
+}
+
+// MigrationPath returns the configuration file config migrate rewrites, the repository file
+// or the one given with --config
+func MigrationPath(args *Args) string {
+	paths := configPaths(args.ConfigFile)
+	return paths[len(paths)-1].path
+}
+
+// nodeRoot is a mapping node that holds top-level sections, the file itself or a profile
+type nodeRoot struct {
+	prefix  string
+	mapping *yaml.Node
+}
+
+// nodeRoots returns the top-level mapping of a file and the mapping of each profile
+func nodeRoots(root *yaml.Node) []nodeRoot {
+	roots := []nodeRoot{{mapping: root}}
+	if _, profiles := mappingEntry(root, "profiles"); profiles != nil && profiles.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			if profiles.Content[i+1].Kind == yaml.MappingNode {
+				roots = append(roots, nodeRoot{
+					prefix:  "profiles." + strings.ToLower(profiles.Content[i].Value) + ".",
+					mapping: profiles.Content[i+1],
+				})
+			}
+		}
+	}
+	return roots
+}
+
+// migrateNode replaces a deprecated key in mapping with its replacements, placed where the
+// key was and carrying its comments. Sections left empty are removed. It reports whether the
+// key was found.
+func migrateNode(mapping *yaml.Node, deprecation *Deprecation) bool {
+	path := strings.Split(deprecation.Key, ".")
+
+	// The mappings from the root to the one holding the key
+	parents := []*yaml.Node{mapping}
+	for _, segment := range path[:len(path)-1] {
+		_, value := mappingEntry(parents[len(parents)-1], segment)
+		if value == nil || value.Kind != yaml.MappingNode {
+			return false
+		}
+		parents = append(parents, value)
+	}
+
+	parent := parents[len(parents)-1]
+	index := entryIndex(parent, path[len(path)-1])
+	if index < 0 {
+		return false
+	}
+	key, value := parent.Content[index], parent.Content[index+1]
+
+	var entries []*yaml.Node
+	for _, replacement := range deprecation.Replacements {
+		name := replacement[strings.LastIndex(replacement, ".")+1:]
+		if !strings.HasPrefix(replacement, strings.Join(path[:len(path)-1], ".")+".") ||
+			entryIndex(parent, name) >= 0 {
+			continue
+		}
+		copied := *value
+		if len(entries) > 0 {
+			// Comments stay with the first replacement
+			copied.HeadComment, copied.LineComment, copied.FootComment = "", "", ""
+		}
+		entries = append(entries, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, &copied)
+	}
+	if len(entries) > 0 {
+		entries[0].HeadComment = key.HeadComment
+		entries[0].LineComment = key.LineComment
+		entries[0].FootComment = key.FootComment
+	}
+	parent.Content = append(parent.Content[:index], append(entries, parent.Content[index+2:]...)...)
+
+	// Remove the sections the key leaves empty, innermost first
+	for i := len(parents) - 1; i > 0 && len(parents[i].Content) == 0; i-- {
+		if index := entryIndex(parents[i-1], path[i-1]); index >= 0 {
+			parents[i-1].Content = append(parents[i-1].Content[:index], parents[i-1].Content[index+2:]...)
+		}
+	}
+
+	return true
+}
+
+// setConfigVersion sets config_version to the current version, as the first key of the file
+func setConfigVersion(root *yaml.Node) {
+	version := strconv.Itoa(CurrentConfigVersion)
+	if _, value := mappingEntry(root, "config_version"); value != nil {
+		value.Value = version
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "config_version"}
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: version}
+	if len(root.Content) > 0 {
+		// The comment at the top of the file stays at the top
+		key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+	}
+	root.Content = append([]*yaml.Node{key, value}, root.Content...)
+}
+
+// entryIndex returns the index of the key node of name in a mapping, -1 if it is not there
+func entryIndex(mapping *yaml.Node, name string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if strings.EqualFold(mapping.Content[i].Value, name) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+const legacyConfig = `# Project configuration
+llm:
+  model: gpt-4o
+
+version:
+  git:
+    tag: true
+    signage: true # GPG-sign releases
+  current: 1.2.3
+
+profiles:
+  release:
+    version:
+      git:
+        signage: false
+`
+
+const migratedConfig = `# Project configuration
+config_version: 2
+llm:
+  model: gpt-4o
+
+version:
+  git:
+    tag: true
+    sign_commit: true # GPG-sign releases
+    sign_tag: true
+
+profiles:
+  release:
+    version:
+      git:
+        sign_commit: false
+        sign_tag: false
+`
+
+func TestMigrate(t *testing.T) {
+	migrated, changes, err := Migrate([]byte(legacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(migrated) != migratedConfig {
+		t.Errorf("got\n%s\nwant\n%s", migrated, migratedConfig)
+	}
+
+	wantChanges := []string{
+		"version.git.signage: replaced by version.git.sign_commit and version.git.sign_tag",
+		"version.current: removed, the current version is read from the latest tag",
+		"profiles.release.version.git.signage: replaced by version.git.sign_commit and version.git.sign_tag",
+		"config_version: set to 2",
+	}
+	if !slices.Equal(changes, wantChanges) {
+		t.Errorf("got changes %q, want %q", changes, wantChanges)
+	}
+
+	// A migrated file is current
+	again, changes, err := Migrate(migrated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 || string(again) != string(migrated) {
+		t.Errorf("migrating again changed %q", changes)
+	}
+}
+
+func TestMigrateKeepsSettings(t *testing.T) {
+	for _, content := range []string{legacyConfig, migratedConfig} {
+		cfg, err := load(writeConfig(t, content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !cfg.Version.Git.SignCommit || !cfg.Version.Git.SignTag {
+			t.Errorf("got %+v, want commits and tags signed", cfg.Version.Git)
+		}
+	}
+
+	cfg, err := load(writeConfig(t, migratedConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.keyIssues) != 0 {
+		t.Errorf("got key issues %v after migrating", cfg.keyIssues)
+	}
+}
+
+func TestMigrateRejectsNewerVersion(t *testing.T) {
+	if _, _, err := Migrate([]byte("config_version: 99\n")); err == nil {
+		t.Error("got no error for a config_version newer than this build")
+	}
+}
@@ -0,0 +1,151 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/spf13/viper"
+)
+
+// ProfileSections are the sections a profile can override
+var ProfileSections = []string{"llm", "git", "version", "commit"}
+
+// Profile is a named set of overrides, selected with --profile, BUMPA_PROFILE or by the
+// remote URL of the repository
+type Profile struct {
+	Match   []string      `mapstructure:"match"` // Remote URL globs such as github.com/acme/*
+	LLM     LLMConfig     `mapstructure:"llm"`
+	Git     GitConfig     `mapstructure:"git"`
+	Version VersionConfig `mapstructure:"version"`
+	Commit  CommitConfig  `mapstructure:"commit"`
+}
+
+// scpURLPattern matches remote URLs in the scp-like form user@host:path
+var scpURLPattern = regexp.MustCompile(`^(?:[^@/]+@)?([^:/]+):(.*)$`)
+
+// selectProfile merges the sections of the selected profile into v, above the configuration
+// files and below the environment and flags. The profile is returned as a layer, none if no
+// profile is selected.
+func selectProfile(v *viper.Viper) ([]fileLayer, error) {
+	profiles := v.GetStringMap("profiles")
+
+	sections := make(map[string]interface{})
+	name := strings.ToLower(v.GetString("profile"))
+	reason := "profile setting"
+	if name == "" {
+		var remote string
+		if name, remote = matchProfile(profiles, remoteURLs()); name == "" {
+			return nil, nil
+		}
+		reason = "remote " + remote
+		sections["profile"] = name
+	}
+
+	settings, ok := profiles[name].(map[string]interface{})
+	if !ok {
+		known := "none"
+		if len(profiles) > 0 {
+			known = strings.Join(sortedKeys(profiles), ", ")
+		}
+		return nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextUnknownProfile, name, known),
+		)
+	}
+
+	for _, section := range ProfileSections {
+		if value, ok := settings[section]; ok {
+			sections[section] = value
+		}
+	}
+
+	layer := viper.New()
+	if err := layer.MergeConfigMap(sections); err != nil {
+		return nil, errors.WrapWithContext(errors.CodeConfigError, err, errors.ContextConfigUnmarshal)
+	}
+	if err := v.MergeConfigMap(sections); err != nil {
+		return nil, errors.WrapWithContext(errors.CodeConfigError, err, errors.ContextConfigUnmarshal)
+	}
+
+	logger.Debug().
+		Str("profile", name).
+		Str("reason", reason).
+		Msg("Profile selected")
+
+	return []fileLayer{{name: LayerProfile, path: "profiles." + name, viper: layer}}, nil
+}
+
+// matchProfile returns the profile with the most specific match pattern matching one of urls,
+// and the URL it matched. Equally specific matches go to the first profile by name.
+func matchProfile(profiles map[string]interface{}, urls []string) (string, string) {
+	var best, bestURL, bestPattern string
+	for _, name := range sortedKeys(profiles) {
+		settings, ok := profiles[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		patterns, _ := settings["match"].([]interface{})
+		for _, pattern := range patterns {
+			pattern, ok := pattern.(string)
+			if !ok || len(pattern) <= len(bestPattern) {
+				continue
+			}
+			for _, url := range urls {
+				if globPattern(pattern).MatchString(NormalizeRemote(url)) {
+					best, bestURL, bestPattern = name, url, pattern
+					break
+				}
+			}
+		}
+	}
+	return best, bestURL
+}
+
+// NormalizeRemote reduces a remote URL to host and path, so that git@github.com:acme/app.git
+// and https://github.com/acme/app both become github.com/acme/app
+func NormalizeRemote(url string) string {
+	if _, rest, found := strings.Cut(url, "://"); found {
+		// The user and port are dropped, ssh://git@host:22/acme/app is host/acme/app too
+		host, path, _ := strings.Cut(rest, "/")
+		if at := strings.LastIndex(host, "@"); at != -1 {
+			host = host[at+1:]
+		}
+		host, _, _ = strings.Cut(host, ":")
+		url = host + "/" + path
+	} else if match := scpURLPattern.FindStringSubmatch(url); match != nil {
+		url = match[1] + "/" + match[2]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(url, "/"), ".git")
+}
+
+// globPattern compiles a match pattern, in which * matches any text including slashes and ?
+// any one character
+func globPattern(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.NewReplacer(`\*`, `.*`, `\?`, `.`).Replace(quoted)
+	return regexp.MustCompile(`^` + quoted + `$`)
+}
+
+// remoteURLs returns the URLs of the remotes of the repository containing the working directory
+func remoteURLs() []string {
+	repo, err := gogit.PlainOpenWithOptions(".", &gogit.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil
+	}
+	remotes, err := repo.Remotes()
+	if err != nil {
+		return nil
+	}
+
+	var urls []string
+	for _, remote := range remotes {
+		urls = append(urls, remote.Config().URLs...)
+	}
+	sort.Strings(urls)
+	return urls
+}
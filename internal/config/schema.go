@@ -0,0 +1,177 @@
+package config
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+const (
+	schemaDraft = "http://json-schema.org/draft-07/schema#"
+	schemaID    = "https://codeberg.org/mutker/bumpa/bumpa.schema.json"
+
+	// durationPattern matches the durations time.ParseDuration accepts
+	durationPattern = `^-?([0-9]+(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+$|^0$`
+)
+
+// schemaEnums lists the accepted values of keys that take one of a few words
+var schemaEnums = map[string][]string{
+	"logging.level": {"debug", "info", "warn", "error", "fatal"},
+	"llm.mode":      {ModeAny, ModeLocalOnly},
+	"llm.overflow":  {OverflowTruncate, OverflowSplit, OverflowSummarize},
+	"commit.mode":   {CommitModeLLM, CommitModeTemplate},
+}
+
+// schemaSkipped are Config keys that only hold command line state
+var schemaSkipped = []string{"command"}
+
+// schemaBuilder collects the definitions of the struct types reachable from Config
+type schemaBuilder struct {
+	definitions  map[string]interface{}
+	defaults     map[string]interface{}
+	descriptions map[string]string
+}
+
+// Schema returns a JSON Schema of the configuration file, derived from the Config types so
+// it follows them as they change. Struct types become definitions, with the defaults and
+// accepted values of their keys as seen from the top level.
+func Schema() map[string]interface{} {
+	b := &schemaBuilder{
+		definitions:  make(map[string]interface{}),
+		defaults:     flagDefaults(),
+		descriptions: make(map[string]string),
+	}
+	for _, alias := range flagAliases {
+		b.descriptions[alias.key] = alias.usage
+	}
+
+	root := b.structSchema(reflect.TypeOf(Config{}), "")
+	root["$schema"] = schemaDraft
+	root["$id"] = schemaID
+	root["title"] = "bumpa configuration"
+	root["definitions"] = b.definitions
+	return root
+}
+
+// WriteSchema writes the JSON Schema of the configuration file to w
+func WriteSchema(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(Schema()); err != nil {
+		return errors.Wrap(errors.CodeIOError, err)
+	}
+	return nil
+}
+
+// typeSchema returns the schema of values of type t, set at key
+func (b *schemaBuilder) typeSchema(t reflect.Type, key string) map[string]interface{} {
+	if t == reflect.TypeOf(time.Duration(0)) {
+		return map[string]interface{}{"type": "string", "pattern": durationPattern}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return b.typeSchema(t.Elem(), key)
+	case reflect.Struct:
+		return b.definition(t, key)
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": b.typeSchema(t.Elem(), key)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": b.typeSchema(t.Elem(), key+".*")}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+// definition returns a reference to the definition of struct type t, adding it the first time
+// the type is seen. Types that refer to themselves, such as Property, are defined once.
+func (b *schemaBuilder) definition(t reflect.Type, key string) map[string]interface{} {
+	ref := map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+	if _, ok := b.definitions[t.Name()]; !ok {
+		b.definitions[t.Name()] = map[string]interface{}{} // Placeholder for recursive types
+		b.definitions[t.Name()] = b.structSchema(t, key)
+	}
+	return ref
+}
+
+// structSchema returns the schema of struct type t, set at key. Unknown keys are rejected, as
+// they are usually misspelled or no longer supported.
+func (b *schemaBuilder) structSchema(t reflect.Type, key string) map[string]interface{} {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := fieldKey(field)
+		if name == "" {
+			continue
+		}
+
+		path := name
+		if key != "" {
+			path = key + "." + name
+		} else if slices.Contains(schemaSkipped, name) {
+			continue
+		}
+		property := b.typeSchema(field.Type, path)
+		if _, isRef := property["$ref"]; !isRef {
+			b.annotate(property, path)
+		}
+		properties[name] = property
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+}
+
+// annotate adds the description, default and accepted values of key to its schema
+func (b *schemaBuilder) annotate(property map[string]interface{}, key string) {
+	if description, ok := b.descriptions[key]; ok {
+		property["description"] = description
+	}
+	if values, ok := schemaEnums[key]; ok {
+		property["enum"] = values
+	}
+	if value, ok := b.defaults[key]; ok {
+		if duration, isDuration := value.(time.Duration); isDuration {
+			value = duration.String()
+		}
+		property["default"] = value
+	}
+}
+
+// fieldKey returns the configuration key of a struct field, empty for fields that are not read
+// from the configuration. Like mapstructure, untagged fields match their name in any case.
+func fieldKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	for _, tag := range []string{"mapstructure", "yaml"} {
+		if value, ok := field.Tag.Lookup(tag); ok {
+			name, _, _ := strings.Cut(value, ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+	}
+	return strings.ToLower(field.Name)
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// schemaProperty returns the schema of the dotted key, following references and map values
+func schemaProperty(t *testing.T, schema map[string]interface{}, key string) map[string]interface{} {
+	t.Helper()
+
+	definitions, _ := schema["definitions"].(map[string]interface{})
+	node := schema
+	for _, segment := range strings.Split(key, ".") {
+		if ref, ok := node["$ref"].(string); ok {
+			node, _ = definitions[strings.TrimPrefix(ref, "#/definitions/")].(map[string]interface{})
+		}
+		if properties, ok := node["properties"].(map[string]interface{}); ok {
+			node, _ = properties[segment].(map[string]interface{})
+		} else {
+			node, _ = node["additionalProperties"].(map[string]interface{})
+		}
+		if node == nil {
+			return nil
+		}
+	}
+	return node
+}
+
+func TestSchemaCoversSettings(t *testing.T) {
+	schema := Schema()
+
+	for key, value := range flagDefaults() {
+		property := schemaProperty(t, schema, key)
+		if property == nil {
+			t.Errorf("%s: missing from the schema", key)
+			continue
+		}
+		if _, isRef := property["$ref"]; isRef {
+			continue
+		}
+		if _, ok := property["default"]; !ok {
+			t.Errorf("%s: no default, want %v", key, value)
+		}
+	}
+
+	if got := schemaProperty(t, schema, "llm.mode")["enum"]; got == nil {
+		t.Error("llm.mode: no accepted values")
+	}
+	if got := schemaProperty(t, schema, "command"); got != nil {
+		t.Errorf("command: got %v, want command line state left out", got)
+	}
+	if got := schemaProperty(t, schema, "llm.request_timeout")["pattern"]; got != durationPattern {
+		t.Errorf("llm.request_timeout: got pattern %v, want a duration", got)
+	}
+}
+
+func TestSchemaCoversExample(t *testing.T) {
+	content, err := os.ReadFile("../../bumpa.example.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		t.Fatal(err)
+	}
+
+	schema := Schema()
+	for key := range scalars("", documentRoot(&root)) {
+		if schemaProperty(t, schema, key) == nil {
+			t.Errorf("%s: set in bumpa.example.yaml but missing from the schema", key)
+		}
+	}
+}
+
+func TestWriteSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteSchema(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("got invalid JSON: %v", err)
+	}
+	if schema["$schema"] != schemaDraft || schema["additionalProperties"] != false {
+		t.Errorf("got $schema %v and additionalProperties %v", schema["$schema"], schema["additionalProperties"])
+	}
+	definitions, _ := schema["definitions"].(map[string]interface{})
+	for _, name := range []string{"LLMFunction", "FunctionParameters", "VersionFile", "Profile"} {
+		if _, ok := definitions[name]; !ok {
+			t.Errorf("no definition of %s", name)
+		}
+	}
+}
@@ -0,0 +1,570 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// Problem severities, only errors fail config validate
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// yamlLinePattern finds the line in yaml.v3 syntax errors
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// yamlParserProblems are the yaml.v3 syntax errors raised by its parser rather than its
+// scanner, which count lines from 0
+var yamlParserProblems = []string{
+	"did not find expected <stream-start>",
+	"did not find expected <document start>",
+	"did not find expected key",
+	"did not find expected node content",
+	"did not find expected '-' indicator",
+	"did not find expected ',' or ']'",
+	"did not find expected ',' or '}'",
+	"found duplicate %YAML directive",
+	"found duplicate %TAG directive",
+	"found incompatible YAML document",
+	"found undefined tag handle",
+}
+
+// Problem is an issue found in the configuration, located where the offending value was set
+type Problem struct {
+	Severity string
+	Key      string // Dotted path, functions are named rather than numbered
+	Source   string // File, environment variable or flag, empty for built-in defaults
+	Line     int    // 0 if the source is not a file or does not contain the key
+	Column   int
+	Message  string
+}
+
+func (p *Problem) String() string {
+	location := p.Source
+	if location == "" {
+		location = "built-in"
+	}
+	if p.Line > 0 {
+		location += ":" + strconv.Itoa(p.Line)
+	}
+	if p.Column > 0 {
+		location += ":" + strconv.Itoa(p.Column)
+	}
+	if p.Key == "" {
+		return fmt.Sprintf("%s: %s: %s", location, p.Severity, p.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s: %s", location, p.Severity, p.Key, p.Message)
+}
+
+// yamlFile is a configuration file parsed into nodes, which keep their position
+type yamlFile struct {
+	path string
+	root *yaml.Node
+}
+
+// validation collects the problems of a configuration
+type validation struct {
+	problems []Problem
+	files    []yamlFile // Highest precedence first
+	sources  Sources
+}
+
+// Validate reads the configuration like Load, but reports every problem it finds with the
+// file, line and column that set the offending value instead of stopping at the first one
+func Validate(args *Args) ([]Problem, error) {
+	v := &validation{}
+	if err := v.parseFiles(args.ConfigFile); err != nil {
+		return nil, err
+	}
+	if v.hasErrors() {
+		return v.problems, nil
+	}
+
+	// Values of the wrong type would fail decoding, their defaults stand in so the remaining
+	// checks still run
+	invalid := v.checkKinds()
+	cfg, err := load(args.withDefaults(invalid))
+	if err != nil {
+		if !v.hasErrors() {
+			v.add(SeverityError, "", err.Error())
+		}
+		return v.problems, nil //nolint:nilerr // Reported as a problem
+	}
+	v.sources = cfg.Sources
+
+	v.checkKeys(cfg)
+	v.checkFunctions(cfg)
+	v.checkVersion(&cfg.Version)
+	v.checkSettings(cfg)
+
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		if v.problems[i].Source != v.problems[j].Source {
+			return v.problems[i].Source < v.problems[j].Source
+		}
+		return v.problems[i].Line < v.problems[j].Line
+	})
+	return v.problems, nil
+}
+
+// parseFiles parses the configuration file of every layer, reporting syntax errors
+func (v *validation) parseFiles(configFile string) error {
+	for _, candidate := range configPaths(configFile) {
+		content, err := os.ReadFile(candidate.path)
+		if err != nil {
+			if os.IsNotExist(err) && candidate.path != configFile {
+				continue
+			}
+			return errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextFileRead, candidate.path),
+			)
+		}
+
+		var root yaml.Node
+		if err := yaml.Unmarshal(content, &root); err != nil {
+			problem := Problem{Severity: SeverityError, Source: candidate.path, Message: err.Error()}
+			if match := yamlLinePattern.FindStringSubmatch(err.Error()); match != nil {
+				problem.Line, _ = strconv.Atoi(match[1])
+				if slices.ContainsFunc(yamlParserProblems, func(p string) bool { return strings.HasSuffix(err.Error(), p) }) {
+					problem.Line++
+				}
+			}
+			v.problems = append(v.problems, problem)
+			continue
+		}
+		v.files = append([]yamlFile{{path: candidate.path, root: &root}}, v.files...)
+	}
+	return nil
+}
+
+// checkKinds reports values that cannot be decoded into the type of their key, judged by its
+// default, and negative durations. It returns the defaults of the keys with such values.
+func (v *validation) checkKinds() map[string]interface{} {
+	defaults := flagDefaults()
+	invalid := make(map[string]interface{})
+	for _, file := range v.files {
+		values := scalars("", documentRoot(file.root))
+		for _, key := range sortedKeys(values) {
+			node := values[key]
+			location := Problem{Source: file.path, Line: node.Line, Column: node.Column}
+
+			// Profile settings are checked like the keys they override, but cannot stand in
+			// with a default without hiding the rest of the profile
+			if setting, ok := profileSetting(key); ok {
+				if kind, ok := defaults[setting]; ok {
+					v.checkKind(key, kind, node.Value, location)
+				}
+				continue
+			}
+
+			kind, ok := defaults[key]
+			if ok && !v.checkKind(key, kind, node.Value, location) {
+				invalid[key] = kind
+			}
+		}
+	}
+
+	for _, key := range sortedKeys(defaults) {
+		if value, ok := os.LookupEnv(envName(key)); ok && !v.checkKind(key, defaults[key], value, Problem{Source: envName(key)}) {
+			invalid[key] = defaults[key]
+		}
+	}
+
+	return invalid
+}
+
+// checkKeys reports unknown and deprecated keys, which are errors if strict is set
+func (v *validation) checkKeys(cfg *Config) {
+	severity := SeverityWarning
+	if cfg.Strict {
+		severity = SeverityError
+	}
+	for _, issue := range cfg.keyIssues {
+		problem := Problem{Severity: severity, Key: issue.key, Source: issue.source, Message: issue.message}
+		for _, file := range v.files {
+			if file.path != issue.source {
+				continue
+			}
+			if node := findNode(documentRoot(file.root), strings.Split(issue.key, ".")); node != nil {
+				problem.Line, problem.Column = node.Line, node.Column
+			}
+		}
+		v.problems = append(v.problems, problem)
+	}
+}
+
+// checkKind reports whether value suits the type of kind, recording a problem if it does not
+func (v *validation) checkKind(key string, kind interface{}, value string, location Problem) bool {
+	location.Severity = SeverityError
+	location.Key = key
+	if expected := checkKind(kind, value); expected != "" {
+		location.Message = fmt.Sprintf("expects %s (got: %s)", expected, value)
+		v.problems = append(v.problems, location)
+		return false
+	}
+	if _, isDuration := kind.(time.Duration); isDuration && strings.HasPrefix(value, "-") {
+		location.Message = "must not be negative"
+		v.problems = append(v.problems, location)
+	}
+	return true
+}
+
+// checkFunctions reports missing prompts and schemas, prompts that do not parse, variables
+// used in prompts without being declared as parameters and, as warnings, the other way around
+func (v *validation) checkFunctions(cfg *Config) {
+	for i := range cfg.Functions {
+		fn := &cfg.Functions[i]
+		key := "functions." + fn.Name
+
+		used := make(map[string]string)
+		for _, prompt := range []struct{ name, text string }{
+			{"system_prompt", fn.SystemPrompt},
+			{"user_prompt", fn.UserPrompt},
+		} {
+			if strings.TrimSpace(prompt.text) == "" {
+				v.add(SeverityError, key+"."+prompt.name, "missing prompt")
+				continue
+			}
+			tmpl, err := template.New(prompt.name).Parse(prompt.text)
+			if err != nil {
+				v.add(SeverityError, key+"."+prompt.name, err.Error())
+				continue
+			}
+			fields := make(map[string]bool)
+			templateFields(tmpl.Root, fields)
+			for field := range fields {
+				if _, ok := used[field]; !ok {
+					used[field] = prompt.name
+				}
+			}
+		}
+
+		for _, field := range sortedKeys(used) {
+			if _, ok := fn.Parameters.Properties[field]; !ok {
+				v.add(SeverityError, key+"."+used[field],
+					fmt.Sprintf("{{.%s}} is not a declared parameter", field))
+			}
+		}
+		for _, param := range sortedKeys(fn.Parameters.Properties) {
+			if _, ok := used[param]; !ok {
+				v.add(SeverityWarning, key+".parameters.properties."+param, "parameter is not used in any prompt")
+			}
+		}
+
+		if len(fn.Output.Properties) == 0 {
+			v.add(SeverityError, key+".output", "missing output schema")
+		}
+	}
+
+	if !hasRequiredFunctions(cfg.Functions) {
+		v.add(SeverityError, "functions", errors.ContextMissingFunctionConfig)
+	}
+	if cfg.LLM.Overflow == OverflowSummarize && FindFunction(cfg.Functions, ReduceFunction) == nil {
+		v.add(SeverityError, "llm.overflow", errors.FormatContext(errors.ContextMissingReduceFunction, ReduceFunction))
+	}
+}
+
+// checkVersion reports invalid channels and version files that do not exist or have replace
+// patterns without {version}
+func (v *validation) checkVersion(cfg *VersionConfig) {
+	valid := true
+	for i, pre := range cfg.Prerelease {
+		if !isValidPrerelease(pre) {
+			v.add(SeverityError, "version.prerelease."+strconv.Itoa(i), "invalid prerelease identifier: "+pre)
+			valid = false
+		}
+	}
+	if valid {
+		if err := cfg.Validate(); err != nil {
+			v.add(SeverityError, "version", err.Error())
+		}
+	}
+
+	root := RepoRoot()
+	for i, file := range cfg.Files {
+		key := "version.files." + strconv.Itoa(i)
+		if file.Path == "" {
+			v.add(SeverityError, key, "missing path")
+			continue
+		}
+		path := file.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if _, err := os.Stat(path); err != nil {
+			severity := SeverityError
+			if len(file.Replace) == 0 {
+				// Files holding nothing but the version are created by the first bump
+				severity = SeverityWarning
+			}
+			v.add(severity, key+".path", "file not found: "+file.Path)
+		}
+		for j, pattern := range file.Replace {
+			if !strings.Contains(pattern, "{version}") {
+				v.add(SeverityError, key+".replace."+strconv.Itoa(j), "replace pattern has no {version}")
+			}
+		}
+	}
+}
+
+// checkSettings reports log levels, modes and regexes that would fail once used
+func (v *validation) checkSettings(cfg *Config) {
+	if !isValidLogLevel(cfg.Logging.Level) {
+		v.add(SeverityError, "logging.level", "must be debug, info, warn, error or fatal (got: "+cfg.Logging.Level+")")
+	}
+	for i := range cfg.Logging.Environments {
+		if level := cfg.Logging.Environments[i].Level; level != "" && !isValidLogLevel(level) {
+			v.add(SeverityError, "logging.environments."+strconv.Itoa(i)+".level",
+				"must be debug, info, warn, error or fatal (got: "+level+")")
+		}
+	}
+
+	if !slices.Contains([]string{"", ModeAny, ModeLocalOnly}, cfg.LLM.Mode) {
+		v.add(SeverityError, "llm.mode", "must be any or local-only (got: "+cfg.LLM.Mode+")")
+	}
+	if !slices.Contains([]string{OverflowTruncate, OverflowSplit, OverflowSummarize}, cfg.LLM.Overflow) {
+		v.add(SeverityError, "llm.overflow", "must be truncate, split or summarize (got: "+cfg.LLM.Overflow+")")
+	}
+	if !slices.Contains([]string{CommitModeLLM, CommitModeTemplate}, cfg.Commit.Mode) {
+		v.add(SeverityError, "commit.mode", "must be llm or template (got: "+cfg.Commit.Mode+")")
+	}
+
+	if key := cfg.LLM.APIKey.Reveal(); key != "" {
+		scheme, target := secret.Split(key)
+		source, _, _ := v.locate("llm.api_key")
+		switch {
+		case scheme == secret.SchemeKeyring && !strings.Contains(target, "/"):
+			v.add(SeverityError, "llm.api_key", "keyring references take the form keyring:<service>/<account>")
+		case scheme == "" && source != envName("llm.api_key"):
+			v.add(SeverityWarning, "llm.api_key", "plaintext key, refer to it with env:, file:, cmd: or keyring: instead")
+		}
+	}
+
+	if _, err := regexp.Compile(cfg.Commit.TicketPattern); err != nil {
+		v.add(SeverityError, "commit.ticket_pattern", err.Error())
+	}
+	for i, pattern := range cfg.Redact.Patterns {
+		if _, err := regexp.Compile(pattern.Regex); err != nil {
+			v.add(SeverityError, "redact.patterns."+strconv.Itoa(i)+".regex", err.Error())
+		}
+	}
+	for i, allow := range cfg.Redact.Allowlist {
+		if _, err := regexp.Compile(allow); err != nil {
+			v.add(SeverityError, "redact.allowlist."+strconv.Itoa(i), err.Error())
+		}
+	}
+}
+
+// withDefaults returns a copy of a setting the given keys to their defaults, above every layer
+func (a *Args) withDefaults(defaults map[string]interface{}) *Args {
+	copied := *a
+	copied.overrides = make(map[string]string, len(a.overrides)+len(defaults))
+	for key, value := range a.overrides {
+		copied.overrides[key] = value
+	}
+	for key, value := range defaults {
+		copied.overrides[key] = fmt.Sprint(value)
+	}
+	return &copied
+}
+
+// add records a problem with key, located in the layer that set it
+func (v *validation) add(severity, key, message string) {
+	problem := Problem{Severity: severity, Key: key, Message: message}
+	if key != "" {
+		problem.Source, problem.Line, problem.Column = v.locate(key)
+	}
+	if severity == SeverityWarning && problem.Source == "" {
+		// Nothing to act on in the built-in defaults
+		return
+	}
+	v.problems = append(v.problems, problem)
+}
+
+func (v *validation) hasErrors() bool {
+	return slices.ContainsFunc(v.problems, func(p Problem) bool {
+		return p.Severity == SeverityError
+	})
+}
+
+// locate returns the source, line and column of key. Values set by the environment or a flag
+// are located there, others in the file of the highest layer that sets the key or, for a
+// missing key, its closest ancestor below the top-level section. Keys set nowhere come from
+// the built-in defaults.
+func (v *validation) locate(key string) (string, int, int) {
+	path := strings.Split(key, ".")
+	if origins := v.sources[key]; len(origins) > 0 {
+		switch origin := origins[len(origins)-1]; origin.Layer {
+		case LayerEnv, LayerFlag:
+			return origin.Source, 0, 0
+		case LayerProfile:
+			path = append(strings.Split(origin.Source, "."), path...)
+		}
+	}
+
+	for _, file := range v.files {
+		if node := findNode(documentRoot(file.root), path); node != nil {
+			return file.path, node.Line, node.Column
+		}
+	}
+	for depth := len(path) - 1; depth >= 2; depth-- {
+		for _, file := range v.files {
+			if node := findNode(documentRoot(file.root), path[:depth]); node != nil {
+				return file.path, node.Line, node.Column
+			}
+		}
+	}
+	return "", 0, 0
+}
+
+// profileSetting reports whether key is set by a profile, and returns the key a setting such
+// as profiles.work.llm.model overrides
+func profileSetting(key string) (string, bool) {
+	path := strings.SplitN(key, ".", 3)
+	if len(path) < 3 || path[0] != "profiles" {
+		return "", false
+	}
+	return path[2], true
+}
+
+// findNode returns the node at path, the key for mapping entries. Sequence items are found by
+// index or, for lists of named entries such as functions, by name.
+func findNode(node *yaml.Node, path []string) *yaml.Node {
+	found := node
+	for _, segment := range path {
+		if node == nil {
+			return nil
+		}
+		var key, value *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			key, value = mappingEntry(node, segment)
+		case yaml.SequenceNode:
+			if index, err := strconv.Atoi(segment); err == nil && index >= 0 && index < len(node.Content) {
+				key, value = node.Content[index], node.Content[index]
+				break
+			}
+			for _, item := range node.Content {
+				if _, name := mappingEntry(item, "name"); name != nil && name.Value == segment {
+					key, value = item, item
+					break
+				}
+			}
+		}
+		if key == nil {
+			return nil
+		}
+		found, node = key, value
+	}
+	return found
+}
+
+// mappingEntry returns the key and value nodes of name in a mapping, matched like viper does,
+// regardless of case
+func mappingEntry(node *yaml.Node, name string) (*yaml.Node, *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, name) {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// documentRoot returns the top-level mapping of a parsed file, nil for an empty one
+func documentRoot(node *yaml.Node) *yaml.Node {
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		return node.Content[0]
+	}
+	return nil
+}
+
+// scalars returns the scalar values of nested mappings by dotted key, lists are not entered
+func scalars(prefix string, node *yaml.Node) map[string]*yaml.Node {
+	values := make(map[string]*yaml.Node)
+	if node == nil || node.Kind != yaml.MappingNode {
+		return values
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key := strings.ToLower(node.Content[i].Value)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch value := node.Content[i+1]; value.Kind {
+		case yaml.ScalarNode:
+			values[key] = value
+		case yaml.MappingNode:
+			for k, v := range scalars(key, value) {
+				values[k] = v
+			}
+		}
+	}
+	return values
+}
+
+// templateFields collects the fields of the top-level data a template uses, such as diff for
+// {{.diff}}. Fields inside range and with blocks belong to other data and are skipped.
+func templateFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFields(child, fields)
+		}
+	case *parse.ActionNode:
+		templateFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateFields(cmd, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFields(arg, fields)
+		}
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.ChainNode:
+		templateFields(n.Node, fields)
+	case *parse.IfNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.List, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.WithNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.ElseList, fields)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
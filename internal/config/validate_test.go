@@ -0,0 +1,111 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes content to a configuration file in a fresh directory, with the user
+// configuration pointed there too, and returns the arguments of a command reading it
+func writeConfig(t *testing.T, content string) *Args {
+	t.Helper()
+
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	path := filepath.Join(dir, ".bumpa.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	args, err := ParseArgs([]string{"--config", path, "config", "validate"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return args
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	tests := []struct {
+		content string
+		line    int
+	}{
+		// Raised by the yaml.v3 parser, which counts lines from 0
+		{"llm:\n  model: gpt-4o\n  provider: [openai\ncommit:\n  mode: llm\n", 3},
+		{"llm:\n  model: gpt-4o\ncommit: {mode: llm\n", 3},
+		// Raised by its scanner, which counts lines from 1
+		{"llm:\n  model: gpt-4o\n  provider: @openai\n", 3},
+		{"llm:\n  model: gpt-4o\n base_url: http://localhost\n", 3},
+	}
+
+	for _, tt := range tests {
+		args := writeConfig(t, tt.content)
+
+		problems, err := Validate(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 {
+			t.Errorf("%q: got problems %v, want the syntax error alone", tt.content, problems)
+			continue
+		}
+		if got := problems[0]; got.Severity != SeverityError || got.Source != args.ConfigFile || got.Line != tt.line {
+			t.Errorf("%q: got %s, want an error on line %d", tt.content, got.String(), tt.line)
+		}
+	}
+}
+
+func TestValidatePositions(t *testing.T) {
+	args := writeConfig(t, `llm:
+  model: gpt-4o
+  max_retries: many
+  mode: remote
+commit:
+  mode: llm
+  ticket_pattern: "([A-Z]+"
+  colour: blue
+version:
+  files:
+    - path: VERSION
+      replace:
+        - "v1.0.0"
+`)
+
+	problems, err := Validate(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		key, severity string
+		line, column  int
+		message       string
+	}{
+		{"llm.max_retries", SeverityError, 3, 16, "expects"},
+		{"llm.mode", SeverityError, 4, 3, "must be any or local-only"},
+		{"commit.ticket_pattern", SeverityError, 7, 3, "missing closing )"},
+		{"commit.colour", SeverityWarning, 8, 3, ""},
+		{"version.files.0.replace.0", SeverityError, 13, 11, "no {version}"},
+	}
+
+	for _, w := range want {
+		var found *Problem
+		for i := range problems {
+			if problems[i].Key == w.key {
+				found = &problems[i]
+				break
+			}
+		}
+		if found == nil {
+			t.Errorf("%s: no problem reported, got %v", w.key, problems)
+			continue
+		}
+		if found.Source != args.ConfigFile || found.Line != w.line || found.Column != w.column {
+			t.Errorf("%s: got %s, want line %d column %d", w.key, found.String(), w.line, w.column)
+		}
+		if found.Severity != w.severity || !strings.Contains(found.Message, w.message) {
+			t.Errorf("%s: got %s, want a %s containing %q", w.key, found.String(), w.severity, w.message)
+		}
+	}
+}
@@ -65,6 +65,8 @@ var (
 	ErrIO                = errors.New("I/O error")
 	ErrRateLimitExceeded = errors.New("rate limit exceeded")
 	ErrInvalidResponse   = errors.New("invalid response")
+	ErrHelpShown         = errors.New("help shown")
+	ErrNoKeyring         = errors.New("no Secret Service available, install secret-tool or use env:, file: or cmd:")
 )
 
 // Error contexts - Layer 4
@@ -72,11 +74,25 @@ const (
 	// Configuration contexts
 	ContextConfigNotFound        = "config file not found"
 	ContextConfigUnmarshal       = "failed to unmarshal configuration"
+	ContextConfigProblems        = "configuration has %d error(s)"
+	ContextConfigStrict          = "configuration has %d unknown or deprecated key(s) and strict is set"
+	ContextConfigNotMapping      = "configuration file is not a mapping of settings"
+	ContextConfigVersion         = "config_version must be an integer (got: %s)"
+	ContextConfigNewer           = "config_version %d is newer than this bumpa reads (%d), upgrade bumpa"
 	ContextInvalidLogLevel       = "invalid log level specified"
 	ContextInvalidTimeFormat     = "invalid time format specified"
 	ContextMissingFunctionConfig = "required function configuration missing"
 	ContextMissingPrompt         = "missing %s prompt for function: %s" // system/user
+	ContextMissingOutput         = "missing output schema for function: %s"
+	ContextMissingReduceFunction = "llm.overflow summarize requires the %s function"
+	ContextInvalidRedactPattern  = "invalid redact pattern: %s"
+	ContextInvalidTemplate       = "invalid %s"
+	ContextInvalidCommitMode     = "commit.mode must be llm or template (got: %s)"
+	ContextInvalidLLMMode        = "llm.mode must be any or local-only (got: %s)"
 	ContextMissingAPIKey         = "API key required for %s provider"
+	ContextSecretResolve         = "failed to resolve api_key from %s"
+	ContextSecretEmpty           = "api_key from %s is empty"
+	ContextUnknownProfile        = "unknown profile: %s (profiles: %s)"
 
 	// Git contexts
 	ContextNoChanges            = "no changes staged for commit - use 'git add' to stage files"
@@ -103,15 +119,23 @@ const (
 	ContextGitDiffTruncated     = "diff truncated at %d lines"
 
 	// LLM contexts
-	ContextLLMRequest         = "failed to make LLM request"
-	ContextLLMResponse        = "failed to decode LLM response"
-	ContextLLMNoChoices       = "no choices in LLM response"
-	ContextLLMEmptyResponse   = "empty response from LLM function"
-	ContextLLMInvalidResponse = "invalid response format from LLM"
-	ContextLLMRateLimit       = "rate limit exceeded"
-	ContextLLMTimeout         = "LLM request timed out"
-	ContextLLMGeneration      = "failed to generate commit message: %s"
-	ContextLLMRetryMessage    = "LLM is struggling to generate a valid commit message - " +
+	ContextLLMRequest          = "failed to make LLM request"
+	ContextLLMResponse         = "failed to decode LLM response"
+	ContextLLMNoChoices        = "no choices in LLM response"
+	ContextLLMEmptyResponse    = "empty response from LLM function"
+	ContextLLMInvalidResponse  = "invalid response format from LLM"
+	ContextLLMInvalidArgs      = "invalid tool call arguments for function %s: %s"
+	ContextLLMRepairFailed     = "output of function %s still invalid after %d attempts"
+	ContextLLMContextWindow    = "prompts of function %s leave no room in the %d token context window"
+	ContextLLMNothingFits      = "not even the first line of the content fits in %d tokens"
+	ContextLLMFixtureMissing   = "no recorded fixture for function %s (%s)"
+	ContextLLMNotLocal         = "llm.mode is local-only but %s is not a loopback or private address"
+	ContextLLMModels           = "failed to list models at %s"
+	ContextLLMRateLimit        = "rate limit exceeded"
+	ContextLLMRateLimitRetries = "rate limit still exceeded after %d retries"
+	ContextLLMTimeout          = "LLM request timed out"
+	ContextLLMGeneration       = "failed to generate commit message: %s"
+	ContextLLMRetryMessage     = "LLM is struggling to generate a valid commit message - " +
 		"try running the command again, make the changes smaller, or commit manually"
 	// Command contexts
 	ContextNoCommand      = "no command specified"
@@ -126,6 +150,7 @@ const (
 	ContextFileDelete  = "failed to delete file: %s"
 	ContextFileRestore = "failed to restore file: %s"
 	ContextDirCreate   = "failed to create directory: %s"
+	ContextHookExists  = "%s exists and was not installed by bumpa, add the hook to it by hand"
 
 	// Version bump contexts
 	ContextVersionAnalyze    = "failed to analyze version changes"
@@ -135,6 +160,8 @@ const (
 	ContextVersionBumpType   = "invalid bump type: %s"
 	ContextVersionPropose    = "failed to propose version change"
 	ContextVersionApply      = "failed to apply version change"
+	ContextVersionRegression = "version %s would precede the current version %s"
+	ContextVersionChannel    = "unknown pre-release channel %s, expected one of %s"
 )
 
 // Helper functions
@@ -19,6 +19,7 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 )
 
 type Repository struct {
@@ -39,10 +40,13 @@ const (
 	UpdatedButUnmerged = gogit.UpdatedButUnmerged
 	newFileMessage     = "[New File]"
 	deletedFileMessage = "[Deleted File]"
+
+	// HunkHeaderPrefix starts the header line of each hunk in generated diffs
+	HunkHeaderPrefix = "@@"
 )
 
 func OpenRepository(path string, cfg config.GitConfig) (*Repository, error) {
-	repo, err := gogit.PlainOpen(path)
+	repo, err := gogit.PlainOpenWithOptions(path, &gogit.PlainOpenOptions{DetectDotGit: true})
 	if err != nil {
 		return nil, errors.WrapWithContext(
 			errors.CodeGitError,
@@ -53,6 +57,32 @@ func OpenRepository(path string, cfg config.GitConfig) (*Repository, error) {
 	return &Repository{repo: repo, cfg: cfg}, nil
 }
 
+// Root returns the root directory of the worktree
+func (r *Repository) Root() (string, error) {
+	w, err := r.repo.Worktree()
+	if err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeGitError,
+			err,
+			errors.ContextGitWorkTree,
+		)
+	}
+	return w.Filesystem.Root(), nil
+}
+
+// GitDir returns the path of the repository's .git directory
+func (r *Repository) GitDir() (string, error) {
+	storage, ok := r.repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.WrapWithContext(
+			errors.CodeGitError,
+			errors.ErrInvalidInput,
+			"repository is not stored on disk",
+		)
+	}
+	return storage.Filesystem().Root(), nil
+}
+
 func (r *Repository) Head() (*plumbing.Reference, error) {
 	head, err := r.repo.Head()
 	if err != nil {
@@ -83,6 +113,16 @@ func (r *Repository) References() (storer.ReferenceIter, error) {
 			"failed to validate references",
 		)
 	}
+
+	// Validation consumed the iterator, callers get a fresh one
+	refs, err = r.repo.References()
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeGitError,
+			err,
+			"failed to get repository references",
+		)
+	}
 	return refs, nil
 }
 
@@ -831,10 +871,16 @@ func (*Repository) generateLineDiff(old, current string) string {
 	newLines := strings.Split(current, "\n")
 
 	var diff strings.Builder
+	lastChanged := -1
 	for i := 0; i < len(oldLines) || i < len(newLines); i++ {
 		if i < len(oldLines) && i < len(newLines) && oldLines[i] == newLines[i] {
 			continue
 		}
+		// Start a new hunk for every run of consecutive changed lines
+		if lastChanged == -1 || i != lastChanged+1 {
+			fmt.Fprintf(&diff, "%s line %d @@\n", HunkHeaderPrefix, i+1)
+		}
+		lastChanged = i
 		if i < len(oldLines) {
 			// Clean and format removed lines
 			line := cleanDiffLine(oldLines[i])
@@ -850,6 +896,26 @@ func (*Repository) generateLineDiff(old, current string) string {
 	return diff.String()
 }
 
+// SplitHunks splits a diff produced by GetFileDiff into its hunks, each starting with its
+// header line. Content before the first header is returned as a hunk of its own.
+func SplitHunks(diff string) []string {
+	var hunks []string
+	var current strings.Builder
+
+	for _, line := range strings.SplitAfter(diff, "\n") {
+		if strings.HasPrefix(line, HunkHeaderPrefix) && current.Len() > 0 {
+			hunks = append(hunks, current.String())
+			current.Reset()
+		}
+		current.WriteString(line)
+	}
+	if current.Len() > 0 {
+		hunks = append(hunks, current.String())
+	}
+
+	return hunks
+}
+
 // cleanDiffLine standardizes a line for diff output
 func cleanDiffLine(line string) string {
 	// Replace tabs with spaces
@@ -0,0 +1,313 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	defaultContextWindow = 8192
+	messageOverhead      = 8 // Tokens per request for roles and message separators
+)
+
+// contextWindows maps model name prefixes to their context window in tokens, most specific first
+var contextWindows = []struct {
+	prefix string
+	tokens int
+}{
+	{"gpt-4o", 128000},
+	{"gpt-4.1", 1047576},
+	{"gpt-4-turbo", 128000},
+	{"gpt-4-32k", 32768},
+	{"gpt-4", 8192},
+	{"gpt-3.5-turbo", 16385},
+	{"o1", 200000},
+	{"o3", 200000},
+	{"o4", 200000},
+	{"claude", 200000},
+	{"llama3.1", 131072},
+	{"llama3.2", 131072},
+	{"llama3.3", 131072},
+	{"llama3", 8192},
+	{"mistral-nemo", 131072},
+	{"mistral", 32768},
+	{"mixtral", 32768},
+	{"qwen2.5", 32768},
+	{"qwen3", 40960},
+	{"deepseek", 65536},
+	{"gemma3", 131072},
+	{"gemma2", 8192},
+	{"phi4", 16384},
+	{"phi3", 4096},
+	{"codellama", 16384},
+}
+
+// ContextWindow returns the context window of model in tokens, or a conservative default for
+// unknown models
+func ContextWindow(model string) int {
+	if idx := strings.LastIndex(model, "/"); idx != -1 {
+		model = model[idx+1:]
+	}
+	model = strings.ToLower(model)
+
+	for _, entry := range contextWindows {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.tokens
+		}
+	}
+	return defaultContextWindow
+}
+
+// Budget decides whether prompts fit the model's context window
+type Budget struct {
+	tokenizer Tokenizer
+	window    int
+	reserve   int
+	overflow  string
+}
+
+// NewBudget creates a budget for the configured model, preferring an explicit context window
+func NewBudget(cfg *config.LLMConfig) *Budget {
+	window := cfg.ContextWindow
+	if window <= 0 {
+		window = ContextWindow(cfg.Model)
+	}
+
+	overflow := cfg.Overflow
+	if overflow == "" {
+		overflow = config.OverflowTruncate
+	}
+
+	tokenizer := NewTokenizer(cfg.Model)
+	logger.Debug().
+		Str("model", cfg.Model).
+		Str("tokenizer", tokenizer.Name()).
+		Int("context_window", window).
+		Int("reserved_output", cfg.MaxOutputTokens).
+		Msg("Token budget initialized")
+
+	return &Budget{
+		tokenizer: tokenizer,
+		window:    window,
+		reserve:   cfg.MaxOutputTokens,
+		overflow:  overflow,
+	}
+}
+
+// Count returns the number of tokens in text
+func (b *Budget) Count(text string) int {
+	return b.tokenizer.Count(text)
+}
+
+// Available returns how many tokens are left for input[field] once the prompts of fn are
+// rendered without it, the tool definition is included and the response is reserved
+func (b *Budget) Available(fn *config.LLMFunction, input map[string]interface{}, field string) (int, error) {
+	empty := withField(input, field, "")
+
+	systemPrompt, err := executeTemplate("system_prompt", fn.SystemPrompt, empty)
+	if err != nil {
+		return 0, err
+	}
+	userPrompt, err := executeTemplate("user_prompt", fn.UserPrompt, empty)
+	if err != nil {
+		return 0, err
+	}
+
+	definition, err := json.Marshal(createFunctionDefinition(fn))
+	if err != nil {
+		return 0, err
+	}
+
+	used := b.Count(systemPrompt) + b.Count(userPrompt) + b.Count(string(definition)) + messageOverhead
+	return b.window - b.reserve - used, nil
+}
+
+// truncate keeps the leading parts that fit in limit tokens and reports how many were dropped.
+// If not even the first part fits, as many of its lines as possible are kept and it counts as
+// kept; if not even its first line fits, an error is returned.
+func (b *Budget) truncate(parts []string, limit int) (string, int, error) {
+	var kept strings.Builder
+	used := 0
+	for i, part := range parts {
+		tokens := b.Count(part)
+		if used+tokens > limit {
+			if i > 0 {
+				return kept.String(), len(parts) - i, nil
+			}
+			lines := b.truncateLines(part, limit)
+			if lines == "" {
+				return "", 0, errors.WrapWithContext(
+					errors.CodeLLMError,
+					errors.ErrInvalidInput,
+					errors.FormatContext(errors.ContextLLMNothingFits, limit),
+				)
+			}
+			return lines, len(parts) - 1, nil
+		}
+		kept.WriteString(part)
+		used += tokens
+	}
+	return kept.String(), 0, nil
+}
+
+// truncateLines keeps the leading lines of text that fit in limit tokens
+func (b *Budget) truncateLines(text string, limit int) string {
+	var kept strings.Builder
+	used := 0
+	for _, line := range strings.SplitAfter(text, "\n") {
+		tokens := b.Count(line)
+		if used+tokens > limit {
+			break
+		}
+		kept.WriteString(line)
+		used += tokens
+	}
+	return kept.String()
+}
+
+// chunk groups consecutive parts into chunks of at most limit tokens, parts larger than limit
+// on their own are cut down to fit
+func (b *Budget) chunk(parts []string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+	used := 0
+
+	for _, part := range parts {
+		tokens := b.Count(part)
+		if tokens > limit {
+			part = b.truncateLines(part, limit)
+			tokens = b.Count(part)
+		}
+		if used+tokens > limit && current.Len() > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			used = 0
+		}
+		current.WriteString(part)
+		used += tokens
+	}
+	if current.Len() > 0 {
+		chunks = append(chunks, current.String())
+	}
+
+	return chunks
+}
+
+// CallFunctionFitted calls fn like CallFunction after fitting the untrusted content in
+// input[field] into the model's context window and fencing it. Content that does not fit is
+// cut at the boundaries between parts: the truncate strategy keeps the leading parts and notes
+// how many were omitted, the split and summarize strategies call fn once per chunk of parts
+// and return every result in order.
+func CallFunctionFitted(
+	ctx context.Context,
+	client Client,
+	budget *Budget,
+	fn *config.LLMFunction,
+	input map[string]interface{},
+	field string,
+	parts []string,
+	maxRetries int,
+) ([]*FunctionResult, error) {
+	// Untrusted content is fenced and the model told not to follow instructions inside it
+	label, ok := input["file"].(string)
+	if !ok {
+		label = field
+	}
+	content := strings.Join(parts, "")
+	guarded := *fn
+	guarded.SystemPrompt = guardSystemPrompt(fn.SystemPrompt, label, detectInjection(label, content))
+	fn = &guarded
+
+	available, err := budget.Available(fn, input, field)
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeTemplateError,
+			err,
+			"failed to render prompts for token budget",
+		)
+	}
+	available -= budget.Count(Fence(label, ""))
+	if available <= 0 {
+		return nil, errors.WrapWithContext(
+			errors.CodeLLMError,
+			errors.ErrInvalidInput,
+			errors.FormatContext(errors.ContextLLMContextWindow, fn.Name, budget.window),
+		)
+	}
+
+	tokens := budget.Count(content)
+	if tokens <= available {
+		result, err := CallFunction(ctx, client, fn, withField(input, field, Fence(label, content)), maxRetries)
+		if err != nil {
+			return nil, err
+		}
+		return []*FunctionResult{result}, nil
+	}
+
+	logger.Info().
+		Interface("file", input["file"]).
+		Int("tokens", tokens).
+		Int("available", available).
+		Str("strategy", budget.overflow).
+		Msg("Input exceeds context window budget")
+
+	if budget.overflow == config.OverflowSplit || budget.overflow == config.OverflowSummarize {
+		chunks := budget.chunk(parts, available)
+		results := make([]*FunctionResult, 0, len(chunks))
+		for _, chunk := range chunks {
+			result, err := CallFunction(ctx, client, fn, withField(input, field, Fence(label, chunk)), maxRetries)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	}
+
+	// Leave room for the omission note
+	kept, omitted, err := budget.truncate(parts, available-messageOverhead)
+	if err != nil {
+		return nil, err
+	}
+	if omitted > 0 {
+		kept += fmt.Sprintf("[... %d more sections omitted]\n", omitted)
+	}
+	result, err := CallFunction(ctx, client, fn, withField(input, field, Fence(label, kept)), maxRetries)
+	if err != nil {
+		return nil, err
+	}
+	return []*FunctionResult{result}, nil
+}
+
+// withField returns a copy of input with field set to value
+func withField(input map[string]interface{}, field string, value interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(input))
+	for k, v := range input {
+		out[k] = v
+	}
+	out[field] = value
+	return out
+}
+
+// detectInjection logs and returns instruction-like phrases found in content
+func detectInjection(label, content string) []Injection {
+	detected := DetectInjection(content)
+	if len(detected) > 0 {
+		phrases := make([]string, 0, len(detected))
+		for _, injection := range detected {
+			phrases = append(phrases, injection.Phrase)
+		}
+		logger.Warn().
+			Str("source", label).
+			Str("kinds", strings.Join(injectionKinds(detected), ", ")).
+			Str("phrases", strings.Join(phrases, "; ")).
+			Msg("Content contains text resembling instructions to the model")
+	}
+	return detected
+}
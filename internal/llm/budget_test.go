@@ -0,0 +1,124 @@
+package llm
+
+import (
+	"reflect"
+	"testing"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+// byteTokenizer counts every byte as a token, which keeps budgets easy to reason about
+type byteTokenizer struct{}
+
+func (byteTokenizer) Name() string          { return "bytes" }
+func (byteTokenizer) Count(text string) int { return len(text) }
+
+func TestContextWindow(t *testing.T) {
+	tests := map[string]int{
+		"gpt-4o-mini":          128000,
+		"gpt-4":                8192,
+		"gpt-4-turbo-preview":  128000,
+		"openai/gpt-4.1":       1047576,
+		"llama3.1:latest":      131072,
+		"llama3:8b":            8192,
+		"Mistral-Nemo":         131072,
+		"qwen2.5-coder:7b":     32768,
+		"some-unknown-model":   defaultContextWindow,
+		"":                     defaultContextWindow,
+		"ollama/phi3:mini-4k":  4096,
+		"anthropic/claude-3.5": 200000,
+	}
+	for model, want := range tests {
+		if got := ContextWindow(model); got != want {
+			t.Errorf("ContextWindow(%q) = %d, want %d", model, got, want)
+		}
+	}
+}
+
+func TestNewBudgetPrefersConfiguredWindow(t *testing.T) {
+	budget := NewBudget(&config.LLMConfig{Model: "gpt-4", ContextWindow: 1000, MaxOutputTokens: 100})
+	if budget.window != 1000 || budget.reserve != 100 || budget.overflow != config.OverflowTruncate {
+		t.Errorf("got window %d, reserve %d, overflow %q", budget.window, budget.reserve, budget.overflow)
+	}
+	if budget := NewBudget(&config.LLMConfig{Model: "gpt-4"}); budget.window != 8192 {
+		t.Errorf("got window %d, want the registry's 8192", budget.window)
+	}
+}
+
+func TestBudgetTruncate(t *testing.T) {
+	parts := []string{"aaaa\n", "bbbb\n", "cccc\n"}
+	tests := []struct {
+		name    string
+		parts   []string
+		limit   int
+		kept    string
+		omitted int
+	}{
+		{name: "all fit", parts: parts, limit: 15, kept: "aaaa\nbbbb\ncccc\n"},
+		{name: "trailing parts dropped", parts: parts, limit: 12, kept: "aaaa\nbbbb\n", omitted: 1},
+		{name: "only the first fits", parts: parts, limit: 5, kept: "aaaa\n", omitted: 2},
+		{
+			name:    "first part cut to its leading lines",
+			parts:   []string{"one\ntwo\nthree\n", "four\n", "five\n"},
+			limit:   9,
+			kept:    "one\ntwo\n",
+			omitted: 2,
+		},
+		{name: "single part cut", parts: []string{"one\ntwo\nthree\n"}, limit: 4, kept: "one\n"},
+	}
+
+	budget := &Budget{tokenizer: byteTokenizer{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kept, omitted, err := budget.truncate(tt.parts, tt.limit)
+			if err != nil {
+				t.Fatalf("truncate: %v", err)
+			}
+			if kept != tt.kept || omitted != tt.omitted {
+				t.Errorf("got %q with %d omitted, want %q with %d omitted", kept, omitted, tt.kept, tt.omitted)
+			}
+		})
+	}
+}
+
+func TestBudgetTruncateNothingFits(t *testing.T) {
+	budget := &Budget{tokenizer: byteTokenizer{}}
+	_, _, err := budget.truncate([]string{"a line longer than the limit\n", "b\n"}, 4)
+	if !errors.Is(err, errors.ErrInvalidInput) {
+		t.Errorf("got %v, want ErrInvalidInput", err)
+	}
+}
+
+func TestBudgetChunk(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []string
+		limit int
+		want  []string
+	}{
+		{name: "all in one", parts: []string{"aa\n", "bb\n"}, limit: 10, want: []string{"aa\nbb\n"}},
+		{
+			name:  "grouped up to the limit",
+			parts: []string{"aa\n", "bb\n", "cc\n", "dd\n", "ee\n"},
+			limit: 6,
+			want:  []string{"aa\nbb\n", "cc\ndd\n", "ee\n"},
+		},
+		{
+			name:  "oversized part cut to fit",
+			parts: []string{"aa\n", "one\ntwo\nthree\n", "bb\n"},
+			limit: 8,
+			want:  []string{"aa\n", "one\ntwo\n", "bb\n"},
+		},
+		{name: "no parts", parts: nil, limit: 8},
+	}
+
+	budget := &Budget{tokenizer: byteTokenizer{}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := budget.chunk(tt.parts, tt.limit); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/cache"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+// CachedClient serves responses for selected functions from an on-disk cache, keyed by
+// model, function name and the rendered prompts
+type CachedClient struct {
+	client    Client
+	store     *cache.Store
+	model     string
+	functions []string
+}
+
+// NewCachedClient wraps client so responses of the given functions are cached in store
+func NewCachedClient(client Client, store *cache.Store, model string, functions []string) *CachedClient {
+	return &CachedClient{
+		client:    client,
+		store:     store,
+		model:     model,
+		functions: functions,
+	}
+}
+
+// Model returns the model of the wrapped client
+func (c *CachedClient) Model() string {
+	return c.model
+}
+
+func (c *CachedClient) GenerateText(ctx context.Context, systemPrompt, userPrompt string, functions []APIFunction) (string, error) {
+	return c.generate(ctx, systemPrompt, userPrompt, functions, nil)
+}
+
+func (c *CachedClient) StreamText(
+	ctx context.Context,
+	systemPrompt, userPrompt string,
+	functions []APIFunction,
+	handler StreamHandler,
+) (string, error) {
+	return c.generate(ctx, systemPrompt, userPrompt, functions, handler)
+}
+
+func (c *CachedClient) generate(
+	ctx context.Context,
+	systemPrompt, userPrompt string,
+	functions []APIFunction,
+	handler StreamHandler,
+) (string, error) {
+	name := functionName(functions)
+	if !slices.Contains(c.functions, name) {
+		return c.forward(ctx, systemPrompt, userPrompt, functions, handler)
+	}
+
+	key := cache.Key(c.model, name, systemPrompt, userPrompt)
+	if entry, ok := c.store.Get(key); ok {
+		logger.Debug().
+			Str("function", name).
+			Str("key", key).
+			Dur("age", time.Since(entry.CreatedAt)).
+			Msg("LLM cache hit")
+		if tracker := usageTrackerFrom(ctx); tracker != nil {
+			tracker.recordCacheHit(name)
+		}
+		if handler != nil {
+			handler(entry.Response, false)
+			handler("", true)
+		}
+		return entry.Response, nil
+	}
+
+	response, err := c.forward(ctx, systemPrompt, userPrompt, functions, handler)
+	if err != nil {
+		return "", err
+	}
+
+	// Responses failing schema validation are removed again through Invalidate
+	if err := c.store.Put(&cache.Entry{
+		Key:       key,
+		Model:     c.model,
+		Function:  name,
+		Response:  response,
+		CreatedAt: time.Now(),
+	}); err != nil {
+		logger.Warn().
+			Err(err).
+			Str("function", name).
+			Msg("Failed to store LLM response in cache")
+	}
+
+	return response, nil
+}
+
+// Invalidate removes the cached response for the given request, used when the response
+// turns out not to match the function's output schema
+func (c *CachedClient) Invalidate(systemPrompt, userPrompt string, functions []APIFunction) {
+	name := functionName(functions)
+	if !slices.Contains(c.functions, name) {
+		return
+	}
+	if err := c.store.Delete(cache.Key(c.model, name, systemPrompt, userPrompt)); err != nil {
+		logger.Warn().
+			Err(err).
+			Str("function", name).
+			Msg("Failed to remove invalid LLM response from cache")
+	}
+}
+
+func (c *CachedClient) forward(
+	ctx context.Context,
+	systemPrompt, userPrompt string,
+	functions []APIFunction,
+	handler StreamHandler,
+) (string, error) {
+	if handler != nil {
+		if streamer, ok := c.client.(StreamingClient); ok {
+			return streamer.StreamText(ctx, systemPrompt, userPrompt, functions, handler)
+		}
+	}
+	return c.client.GenerateText(ctx, systemPrompt, userPrompt, functions)
+}
+
+func functionName(functions []APIFunction) string {
+	if len(functions) == 0 {
+		return ""
+	}
+	return functions[0].Name
+}
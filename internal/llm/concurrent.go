@@ -0,0 +1,70 @@
+package llm
+
+import (
+	"context"
+	"sync"
+)
+
+// RunConcurrent calls fn for every item with at most limit calls in flight and returns the
+// results in input order. The first error cancels the context of the remaining calls and is
+// returned once all started calls have finished.
+func RunConcurrent[T, R any](
+	ctx context.Context,
+	limit int,
+	items []T,
+	fn func(ctx context.Context, item T) (R, error),
+) ([]R, error) {
+	if limit < 1 {
+		limit = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]R, len(items))
+	semaphore := make(chan struct{}, limit)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for i := range items {
+		// Wait for a free slot, stopping early if a call already failed
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result, err := fn(ctx, items[i])
+			if err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+					cancel()
+				})
+				return
+			}
+			results[i] = result
+		}(i)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
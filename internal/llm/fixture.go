@@ -0,0 +1,243 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"codeberg.org/mutker/bumpa/internal/cache"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	fixtureSuffix    = ".json"
+	fixtureKeyLength = 16 // Hex characters of the prompt hash used in fixture file names
+	fixtureDirPerms  = 0o755
+	fixtureFilePerms = 0o644
+	replayModel      = "replay"
+)
+
+// Fixture is a recorded request/response exchange. Identical requests made several times in
+// one recording keep every response, replayed in order with the last one repeating.
+type Fixture struct {
+	Function     string   `json:"function"`
+	Key          string   `json:"key"`
+	SystemPrompt string   `json:"system_prompt"` //nolint:tagliatelle // Snake case like the config files
+	UserPrompt   string   `json:"user_prompt"`   //nolint:tagliatelle // Snake case like the config files
+	Responses    []string `json:"responses"`
+}
+
+// fixtureKey identifies a request by function name and prompts, independent of the model
+func fixtureKey(systemPrompt, userPrompt string, functions []APIFunction) (string, string) {
+	name := functionName(functions)
+	return name, cache.Key(name, systemPrompt, userPrompt)
+}
+
+func fixturePath(dir, name, key string) string {
+	if name == "" {
+		name = textFunctionName
+	}
+	return filepath.Join(dir, name+"-"+key[:fixtureKeyLength]+fixtureSuffix)
+}
+
+// RecordingClient forwards requests to a client and writes every exchange to a fixture
+// directory for later replay
+type RecordingClient struct {
+	client Client
+	dir    string
+
+	mu   sync.Mutex
+	seen map[string]*Fixture // Fixtures written during this run, later responses are appended
+}
+
+// NewRecordingClient wraps client so every exchange is recorded in dir
+func NewRecordingClient(client Client, dir string) *RecordingClient {
+	return &RecordingClient{
+		client: client,
+		dir:    dir,
+		seen:   make(map[string]*Fixture),
+	}
+}
+
+// Model returns the model of the wrapped client
+func (c *RecordingClient) Model() string {
+	if namer, ok := c.client.(modelNamer); ok {
+		return namer.Model()
+	}
+	return ""
+}
+
+func (c *RecordingClient) GenerateText(ctx context.Context, systemPrompt, userPrompt string, functions []APIFunction) (string, error) {
+	response, err := c.client.GenerateText(ctx, systemPrompt, userPrompt, functions)
+	if err != nil {
+		return "", err
+	}
+	c.record(systemPrompt, userPrompt, functions, response)
+	return response, nil
+}
+
+func (c *RecordingClient) StreamText(
+	ctx context.Context,
+	systemPrompt, userPrompt string,
+	functions []APIFunction,
+	handler StreamHandler,
+) (string, error) {
+	streamer, ok := c.client.(StreamingClient)
+	if !ok {
+		return c.GenerateText(ctx, systemPrompt, userPrompt, functions)
+	}
+	response, err := streamer.StreamText(ctx, systemPrompt, userPrompt, functions, handler)
+	if err != nil {
+		return "", err
+	}
+	c.record(systemPrompt, userPrompt, functions, response)
+	return response, nil
+}
+
+// Invalidate passes invalid responses on to the wrapped client. The recording keeps them,
+// so replays see the same repair prompts as the recorded run.
+func (c *RecordingClient) Invalidate(systemPrompt, userPrompt string, functions []APIFunction) {
+	if inv, ok := c.client.(invalidator); ok {
+		inv.Invalidate(systemPrompt, userPrompt, functions)
+	}
+}
+
+func (c *RecordingClient) record(systemPrompt, userPrompt string, functions []APIFunction, response string) {
+	name, key := fixtureKey(systemPrompt, userPrompt, functions)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fixture, ok := c.seen[key]
+	if !ok {
+		fixture = &Fixture{
+			Function:     name,
+			Key:          key,
+			SystemPrompt: systemPrompt,
+			UserPrompt:   userPrompt,
+		}
+		c.seen[key] = fixture
+	}
+	fixture.Responses = append(fixture.Responses, response)
+
+	if err := writeFixture(fixturePath(c.dir, name, key), fixture); err != nil {
+		logger.Warn().
+			Err(err).
+			Str("function", name).
+			Msg("Failed to record LLM fixture")
+	}
+}
+
+func writeFixture(path string, fixture *Fixture) error {
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), fixtureDirPerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextDirCreate, filepath.Dir(path)),
+		)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), fixtureFilePerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, path),
+		)
+	}
+	return nil
+}
+
+// ReplayClient serves recorded fixtures instead of calling a model. Requests without a
+// fixture fail, so workflows run deterministically and offline.
+type ReplayClient struct {
+	dir string
+
+	mu    sync.Mutex
+	calls map[string]int // Responses served per key, selects the next recorded response
+}
+
+// NewReplayClient creates a client replaying the fixtures in dir
+func NewReplayClient(dir string) *ReplayClient {
+	return &ReplayClient{
+		dir:   dir,
+		calls: make(map[string]int),
+	}
+}
+
+// Model returns a fixed name, so replays don't depend on the configured model
+func (*ReplayClient) Model() string {
+	return replayModel
+}
+
+func (c *ReplayClient) GenerateText(_ context.Context, systemPrompt, userPrompt string, functions []APIFunction) (string, error) {
+	name, key := fixtureKey(systemPrompt, userPrompt, functions)
+	path := fixturePath(c.dir, name, key)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.WrapWithContext(
+				errors.CodeLLMError,
+				errors.ErrNotFound,
+				errors.FormatContext(errors.ContextLLMFixtureMissing, name, path),
+			)
+		}
+		return "", errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileRead, path),
+		)
+	}
+
+	var fixture Fixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			errors.FormatContext(errors.ContextFileRead, path),
+		)
+	}
+	if len(fixture.Responses) == 0 {
+		return "", errors.WrapWithContext(
+			errors.CodeLLMError,
+			errors.ErrInvalidResponse,
+			errors.FormatContext(errors.ContextLLMFixtureMissing, name, path),
+		)
+	}
+
+	c.mu.Lock()
+	index := min(c.calls[key], len(fixture.Responses)-1)
+	c.calls[key]++
+	c.mu.Unlock()
+
+	logger.Debug().
+		Str("function", name).
+		Str("fixture", path).
+		Int("response", index).
+		Msg("Replaying LLM fixture")
+
+	return fixture.Responses[index], nil
+}
+
+func (c *ReplayClient) StreamText(
+	ctx context.Context,
+	systemPrompt, userPrompt string,
+	functions []APIFunction,
+	handler StreamHandler,
+) (string, error) {
+	response, err := c.GenerateText(ctx, systemPrompt, userPrompt, functions)
+	if err != nil {
+		return "", err
+	}
+	if handler != nil {
+		handler(response, false)
+		handler("", true)
+	}
+	return response, nil
+}
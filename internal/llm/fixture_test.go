@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+// scriptedClient answers requests with its responses in order, repeating the last one
+type scriptedClient struct {
+	responses []string
+	calls     int
+}
+
+func (c *scriptedClient) GenerateText(context.Context, string, string, []APIFunction) (string, error) {
+	response := c.responses[min(c.calls, len(c.responses)-1)]
+	c.calls++
+	return response, nil
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	summarize := []APIFunction{{Name: "generate_file_summary"}}
+
+	recorder := NewRecordingClient(&scriptedClient{
+		responses: []string{`{"summary":"first"}`, `{"summary":"second"}`, "plain text"},
+	}, dir)
+	for _, want := range []string{`{"summary":"first"}`, `{"summary":"second"}`} {
+		got, err := recorder.GenerateText(ctx, "system", "user", summarize)
+		if err != nil {
+			t.Fatalf("record: %v", err)
+		}
+		if got != want {
+			t.Fatalf("record: got %q, want %q", got, want)
+		}
+	}
+	if _, err := recorder.GenerateText(ctx, "system", "other", nil); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+
+	for _, pattern := range []string{"generate_file_summary-*.json", "text-*.json"} {
+		if matches, _ := filepath.Glob(filepath.Join(dir, pattern)); len(matches) != 1 {
+			t.Errorf("want one fixture matching %s, got %v", pattern, matches)
+		}
+	}
+
+	replay := NewReplayClient(dir)
+	for _, want := range []string{`{"summary":"first"}`, `{"summary":"second"}`, `{"summary":"second"}`} {
+		got, err := replay.GenerateText(ctx, "system", "user", summarize)
+		if err != nil {
+			t.Fatalf("replay: %v", err)
+		}
+		if got != want {
+			t.Errorf("replay: got %q, want %q", got, want)
+		}
+	}
+
+	var streamed strings.Builder
+	got, err := replay.StreamText(ctx, "system", "other", nil, func(fragment string, _ bool) {
+		streamed.WriteString(fragment)
+	})
+	if err != nil {
+		t.Fatalf("replay stream: %v", err)
+	}
+	if got != "plain text" || streamed.String() != "plain text" {
+		t.Errorf("replay stream: got %q, streamed %q", got, streamed.String())
+	}
+
+	if _, err := replay.GenerateText(ctx, "system", "unrecorded", summarize); !errors.Is(err, errors.ErrNotFound) {
+		t.Errorf("replay of an unrecorded request: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestReplayRejectsEmptyFixture(t *testing.T) {
+	dir := t.TempDir()
+	name, key := fixtureKey("system", "user", nil)
+	if err := os.WriteFile(fixturePath(dir, name, key), []byte(`{"responses":[]}`), fixtureFilePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReplayClient(dir).GenerateText(context.Background(), "system", "user", nil); !errors.Is(err, errors.ErrInvalidResponse) {
+		t.Errorf("got %v, want ErrInvalidResponse", err)
+	}
+}
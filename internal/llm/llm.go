@@ -7,7 +7,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"text/template"
@@ -16,11 +15,13 @@ import (
 	"codeberg.org/mutker/bumpa/internal/config"
 	"codeberg.org/mutker/bumpa/internal/errors"
 	"codeberg.org/mutker/bumpa/internal/logger"
+	"codeberg.org/mutker/bumpa/internal/secret"
 )
 
 // Core constants
 const (
 	ProviderOpenAICompatible = "openai-compatible"
+	ProviderOllama           = "ollama"
 	splitPartsExpected       = 2
 )
 
@@ -29,25 +30,60 @@ type Client interface {
 	GenerateText(ctx context.Context, systemPrompt, userPrompt string, functions []APIFunction) (string, error)
 }
 
+// invalidator is implemented by clients that keep responses which may need to be discarded
+type invalidator interface {
+	Invalidate(systemPrompt, userPrompt string, functions []APIFunction)
+}
+
+// modelNamer is implemented by clients that know which model they talk to
+type modelNamer interface {
+	Model() string
+}
+
+// StreamingClient is a Client that can deliver the response incrementally while it is generated
+type StreamingClient interface {
+	Client
+	StreamText(
+		ctx context.Context,
+		systemPrompt, userPrompt string,
+		functions []APIFunction,
+		handler StreamHandler,
+	) (string, error)
+}
+
 // Primary client structure
 type OpenAIClient struct {
 	url         string
+	endpoint    string
 	token       string
 	model       string
 	client      *http.Client
 	rateLimiter *RateLimiter
+	tokenizer   Tokenizer
 }
 
 // Request/Response structures
 type ChatRequest struct {
-	Model     string     `json:"model"`
-	Messages  []Message  `json:"messages"`
-	Functions []Function `json:"tools,omitempty"`
+	Model      string          `json:"model"`
+	Messages   []Message       `json:"messages"`
+	Functions  []Function      `json:"tools,omitempty"`
+	ToolChoice *FunctionChoice `json:"tool_choice,omitempty"` //nolint:tagliatelle // Following OpenAI API spec
+	Stream     bool            `json:"stream"`                // Sent explicitly, Ollama streams by default
+	// StreamOptions asks OpenAI-compatible servers to report usage at the end of a stream
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"` //nolint:tagliatelle // Following OpenAI API spec
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` //nolint:tagliatelle // Following OpenAI API spec
 }
 
 type ChatResponse struct {
-	Choices []MessageChoice `json:"choices"`
-	Error   *APIError       `json:"error,omitempty"`
+	Choices         []MessageChoice  `json:"choices"`
+	Message         *MessageResponse `json:"message,omitempty"` // Ollama native API
+	Usage           *Usage           `json:"usage,omitempty"`
+	PromptEvalCount int              `json:"prompt_eval_count,omitempty"` //nolint:tagliatelle // Ollama native API
+	EvalCount       int              `json:"eval_count,omitempty"`        //nolint:tagliatelle // Ollama native API
+	Error           *APIError        `json:"error,omitempty"`
 }
 
 type Message struct {
@@ -80,6 +116,24 @@ type APIError struct {
 	Code    int    `json:"code,omitempty"`
 }
 
+// UnmarshalJSON accepts the error object of OpenAI-compatible servers as well as the plain
+// string that Ollama's native API sends as {"error":"..."}
+func (e *APIError) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		*e = APIError{Message: message}
+		return nil
+	}
+
+	type apiError APIError // Drops the method to avoid recursing
+	var decoded apiError
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*e = APIError(decoded)
+	return nil
+}
+
 type Parameters struct {
 	Type       string              `json:"type"`
 	Properties map[string]Property `json:"properties"`
@@ -87,9 +141,10 @@ type Parameters struct {
 }
 
 type Property struct {
-	Type        string   `json:"type"`
-	Description string   `json:"description"`
-	Enum        []string `json:"enum,omitempty"`
+	Type        string    `json:"type"`
+	Description string    `json:"description"`
+	Enum        []string  `json:"enum,omitempty"`
+	Items       *Property `json:"items,omitempty"`
 }
 
 type Function struct {
@@ -105,16 +160,19 @@ type FunctionDef struct {
 
 type FunctionCall struct {
 	Function struct {
-		Name      string `json:"name"`
-		Arguments string `json:"arguments"`
+		Name string `json:"name"`
+		// Arguments is a JSON string for OpenAI-compatible servers and a JSON object for Ollama
+		Arguments json.RawMessage `json:"arguments"`
 	} `json:"function"`
 }
 
 type FunctionChoice struct {
-	Type     string `json:"type,omitempty"`
-	Function *struct {
-		Name string `json:"name,omitempty"`
-	} `json:"function,omitempty"`
+	Type     string        `json:"type,omitempty"`
+	Function *FunctionName `json:"function,omitempty"`
+}
+
+type FunctionName struct {
+	Name string `json:"name,omitempty"`
 }
 
 type FunctionCallArguments struct {
@@ -130,29 +188,46 @@ func New(cfg *config.LLMConfig) (Client, error) {
 		Str("provider", cfg.Provider).
 		Str("base_url", cfg.BaseURL).
 		Str("model", cfg.Model).
+		Str("mode", cfg.Mode).
 		Msg("Initializing LLM client")
 
 	if err := validateConfig(cfg); err != nil {
 		return nil, err
 	}
 
-	if cfg.Provider != ProviderOpenAICompatible {
-		return nil, errors.WrapWithContext(
-			errors.CodeConfigError,
-			errors.ErrInvalidConfig,
-			errors.FormatContext("provider must be openai-compatible (got: %s)", cfg.Provider),
-		)
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// References are resolved only now, so commands that never reach a model never run them
+	token, err := secret.Resolve(cfg.APIKey.Reveal())
+	if err != nil {
+		return nil, err
+	}
+
+	// Ollama's native API serves chat at /api/chat instead of /v1/chat/completions
+	endpoint := "/chat/completions"
+	if cfg.Provider == ProviderOllama {
+		endpoint = "/chat"
 	}
 
 	return &OpenAIClient{
 		url:         cfg.BaseURL,
-		token:       cfg.APIKey,
+		endpoint:    endpoint,
+		token:       token,
 		model:       cfg.Model,
-		client:      &http.Client{Timeout: cfg.RequestTimeout},
-		rateLimiter: NewRateLimiter(),
+		client:      httpClient,
+		rateLimiter: NewRateLimiter(cfg.RateLimit),
+		tokenizer:   NewTokenizer(cfg.Model),
 	}, nil
 }
 
+// Model returns the configured model name
+func (c *OpenAIClient) Model() string {
+	return c.model
+}
+
 func (c *OpenAIClient) GenerateText(ctx context.Context, systemPrompt, userPrompt string, apiFunctions []APIFunction) (string, error) {
 	if ctx == nil {
 		return "", errors.WrapWithContext(
@@ -170,28 +245,11 @@ func (c *OpenAIClient) GenerateText(ctx context.Context, systemPrompt, userPromp
 			errors.ContextLLMTimeout,
 		)
 	default:
-		messages := []Message{
-			{Role: "system", Content: systemPrompt},
-			{Role: "user", Content: userPrompt},
-		}
-
-		functions := make([]Function, len(apiFunctions))
-		for i, fn := range apiFunctions {
-			functions[i] = Function{
-				Type:     "function",
-				Function: apiFunctionToFunctionDef(&fn),
-			}
-		}
-
-		request := ChatRequest{
-			Model:     c.model,
-			Messages:  messages,
-			Functions: functions,
-		}
+		request := c.buildRequest(systemPrompt, userPrompt, apiFunctions)
 
 		logger.Debug().
-			Int("message_count", len(messages)).
-			Int("function_count", len(functions)).
+			Int("message_count", len(request.Messages)).
+			Int("function_count", len(request.Functions)).
 			Str("model", c.model).
 			Msg("Preparing LLM request")
 
@@ -204,12 +262,17 @@ func (c *OpenAIClient) GenerateText(ctx context.Context, systemPrompt, userPromp
 			)
 		}
 
+		started := time.Now()
 		resp, err := c.makeRequest(ctx, requestJSON)
 		if err != nil {
 			return "", err
 		}
 
+		// The exchange is recorded even when its content is unusable, the tokens were spent
 		content, err := extractContent(resp)
+		if err == nil || resp.usage() != nil {
+			c.recordUsage(ctx, apiFunctions, resp.usage(), requestJSON, content, started)
+		}
 		if err != nil {
 			return "", err
 		}
@@ -218,14 +281,68 @@ func (c *OpenAIClient) GenerateText(ctx context.Context, systemPrompt, userPromp
 	}
 }
 
+// buildRequest assembles the chat request for the given prompts and tools
+func (c *OpenAIClient) buildRequest(systemPrompt, userPrompt string, apiFunctions []APIFunction) ChatRequest {
+	messages := []Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: userPrompt},
+	}
+
+	functions := make([]Function, len(apiFunctions))
+	for i, fn := range apiFunctions {
+		functions[i] = Function{
+			Type:     "function",
+			Function: apiFunctionToFunctionDef(&fn),
+		}
+	}
+
+	request := ChatRequest{
+		Model:     c.model,
+		Messages:  messages,
+		Functions: functions,
+	}
+
+	// Force the model to answer through the tool when exactly one is offered
+	if len(functions) == 1 {
+		request.ToolChoice = &FunctionChoice{
+			Type:     "function",
+			Function: &FunctionName{Name: functions[0].Function.Name},
+		}
+	}
+
+	return request
+}
+
 func (c *OpenAIClient) makeRequest(ctx context.Context, requestJSON []byte) (*ChatResponse, error) {
-	estimatedTokens := EstimateTokens(requestJSON)
+	resp, err := c.sendRequest(ctx, requestJSON)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			errors.ContextLLMResponse,
+		)
+	}
+	return &result, nil
+}
+
+// sendRequest posts the request, waiting out rate limits, and returns the successful
+// response with its body still open for the caller to consume
+func (c *OpenAIClient) sendRequest(ctx context.Context, requestJSON []byte) (*http.Response, error) {
+	estimatedTokens := c.tokenizer.Count(string(requestJSON))
 	logger.Info().Msgf("Estimated token usage for request: %d", estimatedTokens)
 
-	c.rateLimiter.WaitForCapacity()
+	endpoint := strings.TrimSuffix(c.url, "/") + c.endpoint
+	for attempt := 1; ; attempt++ {
+		if err := c.rateLimiter.WaitForCapacity(ctx, estimatedTokens); err != nil {
+			return nil, err
+		}
 
-	endpoint := strings.TrimSuffix(c.url, "/") + "/chat/completions"
-	for {
 		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewBuffer(requestJSON))
 		if err != nil {
 			return nil, errors.WrapWithContext(
@@ -251,7 +368,6 @@ func (c *OpenAIClient) makeRequest(ctx context.Context, requestJSON []byte) (*Ch
 
 		rateLimitInfo, err := parseRateLimitHeaders(resp.Header)
 		if err != nil {
-			resp.Body.Close()
 			logger.Warn().Err(err).Msg("Failed to parse rate limit headers")
 		} else {
 			c.rateLimiter.UpdateLimits(rateLimitInfo)
@@ -260,55 +376,69 @@ func (c *OpenAIClient) makeRequest(ctx context.Context, requestJSON []byte) (*Ch
 		if resp.StatusCode == http.StatusTooManyRequests {
 			resp.Body.Close()
 
-			// Log current status and wait time
-			waitTime := defaultRetryDuration
-			if rateLimitInfo.RetryAfter > 0 {
-				waitTime = rateLimitInfo.RetryAfter
+			waitTime, ok := c.rateLimiter.Backoff(attempt, rateLimitInfo.RetryAfter)
+			if !ok {
+				return nil, errors.WrapWithContext(
+					errors.CodeLLMError,
+					errors.ErrRateLimitExceeded,
+					errors.FormatContext(errors.ContextLLMRateLimitRetries, attempt-1),
+				)
 			}
 
-			logger.Debug().
+			logger.Warn().
+				Int("attempt", attempt).
 				Int("estimated_tokens", estimatedTokens).
 				Int("remaining_tokens", rateLimitInfo.RemainingTokens).
 				Float64("wait_time_seconds", waitTime.Seconds()).
 				Time("reset_at", time.Now().Add(waitTime)).
 				Msg("Rate limit reached, waiting before retry")
 
-			time.Sleep(waitTime)
+			if err := sleepContext(ctx, waitTime); err != nil {
+				return nil, errors.WrapWithContext(
+					errors.CodeTimeoutError,
+					err,
+					errors.ContextLLMRateLimit,
+				)
+			}
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
 			resp.Body.Close()
+			message := string(body)
+			var failed ChatResponse
+			if json.Unmarshal(body, &failed) == nil && failed.Error != nil && failed.Error.Message != "" {
+				message = failed.Error.Message
+			}
 			return nil, errors.WrapWithContext(
 				errors.CodeLLMError,
 				errors.ErrLLMStatus,
-				fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(body)),
+				fmt.Sprintf("HTTP %d: %s", resp.StatusCode, message),
 			)
 		}
 
-		var result ChatResponse
-		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-			resp.Body.Close()
-			return nil, errors.WrapWithContext(
-				errors.CodeLLMError,
-				err,
-				errors.ContextLLMResponse,
-			)
-		}
-		resp.Body.Close()
-		return &result, nil
+		return resp, nil
 	}
 }
 
 // Function-related functions
-func CallFunction(ctx context.Context, client Client, fn *config.LLMFunction, input map[string]interface{}) (string, error) {
+// CallFunction renders the function prompts, forces the model to call the function's tool and
+// returns the decoded tool arguments validated against the function's output schema. Output that
+// fails validation is sent back to the model with the errors, up to maxRetries attempts in total.
+func CallFunction(
+	ctx context.Context,
+	client Client,
+	fn *config.LLMFunction,
+	input map[string]interface{},
+	maxRetries int,
+) (*FunctionResult, error) {
 	startTime := time.Now()
 
 	// Get the model being used
 	var model string
-	if openAIClient, ok := client.(*OpenAIClient); ok {
-		model = openAIClient.model
+	if namer, ok := client.(modelNamer); ok {
+		model = namer.Model()
 	}
 
 	logEvent := logger.Info().
@@ -322,13 +452,13 @@ func CallFunction(ctx context.Context, client Client, fn *config.LLMFunction, in
 	logEvent.Msg("Calling LLM function: " + fn.Name)
 
 	if err := validateFunctionConfig(fn); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	functionDef := createFunctionDefinition(fn)
 
 	if err := validateFunction(&functionDef); err != nil {
-		return "", err
+		return nil, err
 	}
 
 	// Debug log the input data
@@ -340,7 +470,7 @@ func CallFunction(ctx context.Context, client Client, fn *config.LLMFunction, in
 	// Execute templates for both prompts
 	systemPrompt, err := executeTemplate("system_prompt", fn.SystemPrompt, input)
 	if err != nil {
-		return "", errors.WrapWithContext(
+		return nil, errors.WrapWithContext(
 			errors.CodeTemplateError,
 			err,
 			"failed to execute system prompt template",
@@ -349,7 +479,7 @@ func CallFunction(ctx context.Context, client Client, fn *config.LLMFunction, in
 
 	userPrompt, err := executeTemplate("user_prompt", fn.UserPrompt, input)
 	if err != nil {
-		return "", errors.WrapWithContext(
+		return nil, errors.WrapWithContext(
 			errors.CodeTemplateError,
 			err,
 			"failed to execute user prompt template",
@@ -363,41 +493,93 @@ func CallFunction(ctx context.Context, client Client, fn *config.LLMFunction, in
 		Str("processed_user_prompt", userPrompt).
 		Msg("Processed prompts")
 
-	response, err := client.GenerateText(ctx, systemPrompt, userPrompt, []APIFunction{functionDef})
-	if err != nil {
+	if maxRetries < 1 {
+		maxRetries = 1
+	}
+
+	// Re-prompt with the validation errors until the output satisfies the schema
+	prompt := userPrompt
+	var lastErr error
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		response, err := generate(ctx, client, systemPrompt, prompt, []APIFunction{functionDef})
+		if err != nil {
+			logger.Warn().
+				Err(err).
+				Str("function", fn.Name).
+				Msg("LLM call failed")
+			return nil, err
+		}
+
+		result, err := decodeFunctionResult(fn, response)
+		if err == nil {
+			if check := outputCheckFrom(ctx); check != nil {
+				if violations := check(result); len(violations) > 0 {
+					err = &invalidOutputError{function: fn.Name, response: response, violations: violations}
+				}
+			}
+		}
+		if err == nil {
+			logger.Debug().
+				Str("function", fn.Name).
+				Int("response_length", len(response)).
+				Int("attempt", attempt).
+				Dur("duration", time.Since(startTime)).
+				Msg("LLM function execution completed")
+			return result, nil
+		}
+
+		var invalid *invalidOutputError
+		if !errors.As(err, &invalid) {
+			return nil, err
+		}
+		lastErr = err
+
+		if cached, ok := client.(invalidator); ok {
+			cached.Invalidate(systemPrompt, prompt, []APIFunction{functionDef})
+		}
+
 		logger.Warn().
-			Err(err).
 			Str("function", fn.Name).
-			Msg("LLM call failed")
-		return "", err
-	}
+			Int("attempt", attempt).
+			Int("max_retries", maxRetries).
+			Str("violations", invalid.violations.Error()).
+			Msg("LLM output does not match the function schema")
 
-	response = processFunctionResponse(response, fn.Name)
-	if response == "" {
-		return "", errors.WrapWithContext(
-			errors.CodeLLMError,
-			errors.ErrInvalidInput,
-			errors.ContextLLMEmptyResponse,
-		)
+		prompt = buildRepairPrompt(userPrompt, invalid)
 	}
 
-	logger.Debug().
-		Str("function", fn.Name).
-		Int("response_length", len(response)).
-		Dur("duration", time.Since(startTime)).
-		Msg("LLM function execution completed")
+	return nil, errors.WrapWithContext(
+		errors.CodeLLMError,
+		lastErr,
+		errors.FormatContext(errors.ContextLLMRepairFailed, fn.Name, maxRetries),
+	)
+}
 
-	return response, nil
+// buildRepairPrompt extends the original user prompt with the rejected output and the
+// schema violations, so the model can correct its previous answer
+func buildRepairPrompt(userPrompt string, invalid *invalidOutputError) string {
+	var prompt strings.Builder
+	prompt.WriteString(userPrompt)
+	prompt.WriteString("\n\nYour previous answer was rejected:\n")
+	prompt.WriteString(invalid.response)
+	prompt.WriteString("\n\nValidation errors:\n")
+	for _, violation := range invalid.violations {
+		prompt.WriteString("- " + violation.String() + "\n")
+	}
+	prompt.WriteString("\nCall the " + invalid.function + " function again with arguments that satisfy its schema.")
+	return prompt.String()
 }
 
+// createFunctionDefinition builds the tool definition from the function's output schema, since
+// the tool arguments are what the model fills in
 func createFunctionDefinition(fn *config.LLMFunction) APIFunction {
 	return APIFunction{
 		Name:        fn.Name,
 		Description: fn.Description,
 		Parameters: Parameters{
-			Type:       fn.Parameters.Type,
-			Properties: convertProperties(fn.Parameters.Properties),
-			Required:   fn.Parameters.Required,
+			Type:       fn.Output.Type,
+			Properties: convertProperties(fn.Output.Properties),
+			Required:   fn.Output.Required,
 		},
 	}
 }
@@ -410,44 +592,6 @@ func apiFunctionToFunctionDef(fn *APIFunction) FunctionDef {
 	return FunctionDef(*fn)
 }
 
-func processFunctionResponse(response, functionName string) string {
-	if strings.HasPrefix(response, "{") && strings.HasSuffix(response, "}") {
-		var functionResponse struct {
-			Summary string `json:"summary"`
-			Message string `json:"message"`
-			Content string `json:"content"`
-			File    string `json:"file"`
-			Status  string `json:"status"`
-			Diff    string `json:"diff"`
-		}
-
-		if err := json.Unmarshal([]byte(response), &functionResponse); err == nil {
-			// Check fields in priority order
-			if functionResponse.Summary != "" {
-				return functionResponse.Summary
-			}
-			if functionResponse.Message != "" {
-				return functionResponse.Message
-			}
-			if functionResponse.Content != "" {
-				return functionResponse.Content
-			}
-
-			// If we have file info but no summary, construct a basic one
-			if functionResponse.File != "" {
-				return "update %s" + filepath.Base(functionResponse.File)
-			}
-		}
-
-		logger.Debug().
-			Str("function_name", functionName).
-			Str("response", response).
-			Msg("Received JSON response but couldn't extract expected fields")
-	}
-
-	return cleanResponse(response)
-}
-
 func validateFunction(fn *APIFunction) error {
 	if fn == nil {
 		return errors.WrapWithContext(
@@ -493,11 +637,11 @@ func validateConfig(cfg *config.LLMConfig) error {
 			"LLM configuration is required",
 		)
 	}
-	if cfg.Provider != ProviderOpenAICompatible {
+	if cfg.Provider != ProviderOpenAICompatible && cfg.Provider != ProviderOllama {
 		return errors.WrapWithContext(
 			errors.CodeConfigError,
 			errors.ErrInvalidConfig,
-			"provider must be openai-compatible",
+			errors.FormatContext("provider must be openai-compatible or ollama (got: %s)", cfg.Provider),
 		)
 	}
 	if cfg.BaseURL == "" {
@@ -514,6 +658,24 @@ func validateConfig(cfg *config.LLMConfig) error {
 			"Model is required",
 		)
 	}
+	switch cfg.Mode {
+	case "", config.ModeAny, config.ModeLocalOnly:
+	default:
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextInvalidLLMMode, cfg.Mode),
+		)
+	}
+	switch cfg.Overflow {
+	case "", config.OverflowTruncate, config.OverflowSplit, config.OverflowSummarize:
+	default:
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext("overflow must be truncate, split or summarize (got: %s)", cfg.Overflow),
+		)
+	}
 	return nil
 }
 
@@ -566,6 +728,14 @@ func validateFunctionConfig(fn *config.LLMFunction) error {
 		)
 	}
 
+	if len(fn.Output.Properties) == 0 {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidInput,
+			errors.FormatContext(errors.ContextMissingOutput, fn.Name),
+		)
+	}
+
 	return nil
 }
 
@@ -573,15 +743,24 @@ func validateFunctionConfig(fn *config.LLMFunction) error {
 func convertProperties(configProps map[string]config.Property) map[string]Property {
 	properties := make(map[string]Property, len(configProps))
 	for k, v := range configProps {
-		properties[k] = Property{
-			Type:        v.Type,
-			Description: v.Description,
-			Enum:        v.Enum,
-		}
+		properties[k] = convertProperty(&v)
 	}
 	return properties
 }
 
+func convertProperty(prop *config.Property) Property {
+	converted := Property{
+		Type:        prop.Type,
+		Description: prop.Description,
+		Enum:        prop.Enum,
+	}
+	if prop.Items != nil {
+		items := convertProperty(prop.Items)
+		converted.Items = &items
+	}
+	return converted
+}
+
 func cleanResponse(response string) string {
 	originalLength := len(response)
 
@@ -625,6 +804,11 @@ func extractContent(resp *ChatResponse) (string, error) {
 		)
 	}
 
+	// Ollama's native API returns a single message instead of choices
+	if len(resp.Choices) == 0 && resp.Message != nil {
+		resp.Choices = []MessageChoice{{Message: *resp.Message}}
+	}
+
 	if len(resp.Choices) == 0 {
 		return "", errors.WrapWithContext(
 			errors.CodeLLMError,
@@ -643,7 +827,7 @@ func extractContent(resp *ChatResponse) (string, error) {
 
 	// Check for function calls
 	if len(choice.Message.FunctionCalls) > 0 {
-		return choice.Message.FunctionCalls[0].Function.Arguments, nil
+		return decodeArgumentsFragment(choice.Message.FunctionCalls[0].Function.Arguments), nil
 	}
 
 	if choice.Message.Content != "" {
@@ -657,19 +841,15 @@ func extractContent(resp *ChatResponse) (string, error) {
 	)
 }
 
-func EstimateTokens(requestJSON []byte) int {
-	return len(requestJSON) / tokenSizeMultiplier
-}
-
 func parseRateLimitHeaders(headers http.Header) (RateLimitInfo, error) {
 	info := RateLimitInfo{}
 	var parseErr error
 
-	// Helper function to parse integers from headers
+	// Helper function to parse integers from headers, missing headers are reported as unknown
 	parseIntHeader := func(header string) (int, error) {
 		val := headers.Get(header)
 		if val == "" {
-			return 0, nil
+			return unknownLimit, nil
 		}
 		return strconv.Atoi(val)
 	}
@@ -718,17 +898,20 @@ func parseRateLimitHeaders(headers http.Header) (RateLimitInfo, error) {
 	}
 
 	// Parse retry-after
+	// Retry-After is either a number of seconds or an HTTP date
 	//nolint:canonicalheader // Using lowercase as per API spec
 	if retryAfter := headers.Get(headerRetryAfter); retryAfter != "" {
-		seconds, err := strconv.Atoi(retryAfter)
-		if err != nil {
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			info.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, dateErr := http.ParseTime(retryAfter); dateErr == nil {
+			info.RetryAfter = max(time.Until(at), 0)
+		} else {
 			return info, errors.WrapWithContext(
 				errors.CodeLLMError,
 				err,
 				"invalid retry-after header",
 			)
 		}
-		info.RetryAfter = time.Duration(seconds) * time.Second
 	}
 
 	return info, nil
@@ -0,0 +1,145 @@
+package llm_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/llm"
+	"codeberg.org/mutker/bumpa/internal/llm/llmtest"
+)
+
+func newClient(t *testing.T, server *llmtest.Server) llm.Client {
+	t.Helper()
+
+	client, err := llm.New(server.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestRateLimitedRequestIsRetried(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.Script(llmtest.DefaultFunction, llmtest.RateLimited(0), llmtest.RateLimited(0), llmtest.Text("done"))
+
+	got, err := newClient(t, server).GenerateText(context.Background(), "system", "user", nil)
+	if err != nil {
+		t.Fatalf("GenerateText: %v", err)
+	}
+	if got != "done" {
+		t.Errorf("got %q, want %q", got, "done")
+	}
+	if calls := server.Calls(llmtest.DefaultFunction); calls != 3 {
+		t.Errorf("got %d requests, want 3", calls)
+	}
+}
+
+func TestRateLimitRetriesRunOut(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.Script(llmtest.DefaultFunction, llmtest.RateLimited(0))
+
+	cfg := server.Config()
+	cfg.RateLimit.MaxRetries = 2
+	client, err := llm.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.GenerateText(context.Background(), "system", "user", nil)
+	if !errors.Is(err, errors.ErrRateLimitExceeded) {
+		t.Fatalf("got %v, want ErrRateLimitExceeded", err)
+	}
+	if calls := server.Calls(llmtest.DefaultFunction); calls != 3 {
+		t.Errorf("got %d requests, want the first and 2 retries", calls)
+	}
+}
+
+func TestRetryAfterIsHonored(t *testing.T) {
+	server := llmtest.NewServer()
+	defer server.Close()
+	server.Script(llmtest.DefaultFunction, llmtest.RateLimited(time.Second), llmtest.Text("done"))
+
+	started := time.Now()
+	if _, err := newClient(t, server).GenerateText(context.Background(), "system", "user", nil); err != nil {
+		t.Fatalf("GenerateText: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < time.Second {
+		t.Errorf("retried after %s, before the server's Retry-After of 1s", elapsed)
+	}
+}
+
+func TestFailedResponses(t *testing.T) {
+	tests := []struct {
+		name     string
+		response llmtest.Response
+		sentinel error  // Wrapped by the error if set
+		message  string // Contained in the error if set
+	}{
+		{
+			name:     "malformed body",
+			response: llmtest.Malformed(),
+			message:  "failed to decode LLM response",
+		},
+		{
+			name:     "no choices",
+			response: llmtest.Empty(),
+			sentinel: errors.ErrInvalidInput,
+			message:  "no choices in LLM response",
+		},
+		{
+			name:     "server error",
+			response: llmtest.ServerError(http.StatusInternalServerError, "model overloaded"),
+			sentinel: errors.ErrLLMStatus,
+			message:  "HTTP 500: model overloaded",
+		},
+		{
+			name:     "string error",
+			response: llmtest.Response{Status: http.StatusNotFound, Body: `{"error":"model 'llmtest' not found"}`},
+			sentinel: errors.ErrLLMStatus,
+			message:  "HTTP 404: model 'llmtest' not found",
+		},
+		{
+			name:     "error in completion",
+			response: llmtest.Response{Body: `{"error":{"message":"context length exceeded","type":"invalid_request_error"}}`},
+			sentinel: errors.ErrLLMStatus,
+			message:  "context length exceeded",
+		},
+		{
+			name:     "slow response",
+			response: llmtest.Slow(time.Second, llmtest.Text("too late")),
+			message:  "failed to make LLM request",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := llmtest.NewServer()
+			defer server.Close()
+			server.Script(llmtest.DefaultFunction, tt.response)
+
+			cfg := server.Config()
+			cfg.RequestTimeout = 100 * time.Millisecond
+			client, err := llm.New(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.GenerateText(context.Background(), "system", "user", nil)
+			if err == nil {
+				t.Fatal("got no error")
+			}
+			if tt.sentinel != nil && !errors.Is(err, tt.sentinel) {
+				t.Errorf("got %v, want it to wrap %v", err, tt.sentinel)
+			}
+			if !strings.Contains(err.Error(), tt.message) {
+				t.Errorf("got %v, want it to contain %q", err, tt.message)
+			}
+		})
+	}
+}
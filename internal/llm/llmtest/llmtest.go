@@ -0,0 +1,351 @@
+// Package llmtest provides an in-process OpenAI-compatible server with scripted responses,
+// for exercising bumpa's LLM client and workflows without a model.
+package llmtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/llm"
+)
+
+const (
+	// DefaultFunction scripts responses for requests that offer no tools, or for any
+	// function without its own script
+	DefaultFunction = ""
+
+	model          = "llmtest"
+	streamChunks   = 3 // Fragments each streamed response is split into
+	defaultTimeout = 5 * time.Second
+)
+
+// Response is one scripted reply. Status, Body, EmptyChoices and Delay let tests inject
+// failures; otherwise a successful completion carrying Arguments or Content is sent.
+type Response struct {
+	Arguments    string            // Tool call arguments as JSON
+	Content      string            // Plain message content, used when Arguments is empty
+	Status       int               // HTTP status, 200 if zero
+	Headers      map[string]string // Extra response headers, e.g. rate limit headers
+	Body         string            // Raw body sent instead of a completion, e.g. malformed JSON
+	EmptyChoices bool              // Send a completion without choices
+	Delay        time.Duration     // Wait before responding, aborted when the client gives up
+	Usage        *llm.Usage        // Usage reported with the completion
+}
+
+// Server is a fake OpenAI-compatible chat completions endpoint
+type Server struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	scripts  map[string][]Response
+	served   map[string]int
+	requests []llm.ChatRequest
+}
+
+// NewServer starts a server. Close it when done.
+func NewServer() *Server {
+	s := &Server{
+		scripts: make(map[string][]Response),
+		served:  make(map[string]int),
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Close shuts the server down
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL returns the base URL to configure as llm.base_url
+func (s *Server) URL() string {
+	return s.server.URL + "/v1"
+}
+
+// Config returns an LLM configuration pointing at the server with fast retries
+func (s *Server) Config() *config.LLMConfig {
+	return &config.LLMConfig{
+		Provider:         llm.ProviderOpenAICompatible,
+		Model:            model,
+		BaseURL:          s.URL(),
+		MaxRetries:       config.DefaultMaxRetries,
+		RequestTimeout:   defaultTimeout,
+		CommitMsgTimeout: defaultTimeout,
+		Concurrency:      1,
+		MaxOutputTokens:  config.DefaultMaxOutputTokens,
+		RateLimit: config.RateLimitConfig{
+			MaxRetries:     config.DefaultRateLimitRetries,
+			InitialBackoff: time.Millisecond,
+			MaxBackoff:     10 * time.Millisecond,
+		},
+	}
+}
+
+// Script queues responses for requests to function. They are served in order and the last
+// one repeats once the rest are used up.
+func (s *Server) Script(function string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scripts[function] = append(s.scripts[function], responses...)
+}
+
+// Requests returns every request received so far
+func (s *Server) Requests() []llm.ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]llm.ChatRequest(nil), s.requests...)
+}
+
+// Calls returns how many requests were made for function
+func (s *Server) Calls(function string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.served[function]
+}
+
+// ToolCall scripts a successful call of the function's tool with args
+func ToolCall(args map[string]interface{}) (Response, error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return Response{}, err
+	}
+	return Response{Arguments: string(data)}, nil
+}
+
+// Text scripts a successful plain text answer
+func Text(content string) Response {
+	return Response{Content: content}
+}
+
+// RateLimited scripts a 429 with the headers OpenAI sends when the request budget is used up
+func RateLimited(retryAfter time.Duration) Response {
+	return Response{
+		Status: http.StatusTooManyRequests,
+		Headers: map[string]string{
+			"retry-after":                    strconv.Itoa(int(retryAfter.Seconds())),
+			"x-ratelimit-remaining-requests": "0",
+			"x-ratelimit-remaining-tokens":   "0",
+			"x-ratelimit-reset-requests":     retryAfter.String(),
+			"x-ratelimit-reset-tokens":       retryAfter.String(),
+		},
+		Body: `{"error":{"message":"Rate limit reached","type":"requests"}}`,
+	}
+}
+
+// Malformed scripts a response body that isn't valid JSON
+func Malformed() Response {
+	return Response{Body: `{"choices":[{"message":`}
+}
+
+// Empty scripts a completion without any choices
+func Empty() Response {
+	return Response{EmptyChoices: true}
+}
+
+// ServerError scripts an HTTP error with an OpenAI error body
+func ServerError(status int, message string) Response {
+	body, _ := json.Marshal(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": "server_error"},
+	})
+	return Response{Status: status, Body: string(body)}
+}
+
+// Slow delays response by d
+func Slow(d time.Duration, response Response) Response {
+	response.Delay = d
+	return response
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	var request llm.ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+
+	function := requestedFunction(&request)
+	response, ok := s.next(function, &request)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("no scripted response for function %q", function))
+		return
+	}
+
+	if response.Delay > 0 {
+		select {
+		case <-time.After(response.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	for name, value := range response.Headers {
+		w.Header().Set(name, value)
+	}
+
+	switch {
+	case response.Body != "":
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusOrOK(response.Status))
+		_, _ = w.Write([]byte(response.Body))
+	case response.Status != 0 && response.Status != http.StatusOK:
+		writeError(w, response.Status, http.StatusText(response.Status))
+	case request.Stream:
+		writeStream(w, function, &response)
+	default:
+		writeCompletion(w, function, &response)
+	}
+}
+
+// next records the request and returns the scripted response for function, falling back
+// to the default script
+func (s *Server) next(function string, request *llm.ChatRequest) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, *request)
+
+	script, ok := s.scripts[function]
+	if !ok || len(script) == 0 {
+		script = s.scripts[DefaultFunction]
+	}
+	if len(script) == 0 {
+		return Response{}, false
+	}
+
+	index := min(s.served[function], len(script)-1)
+	s.served[function]++
+	return script[index], true
+}
+
+// requestedFunction returns the forced tool, or the only offered tool
+func requestedFunction(request *llm.ChatRequest) string {
+	if request.ToolChoice != nil && request.ToolChoice.Function != nil {
+		return request.ToolChoice.Function.Name
+	}
+	if len(request.Functions) == 1 {
+		return request.Functions[0].Function.Name
+	}
+	return DefaultFunction
+}
+
+func statusOrOK(status int) int {
+	if status == 0 {
+		return http.StatusOK
+	}
+	return status
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": "llmtest"},
+	})
+}
+
+func writeCompletion(w http.ResponseWriter, function string, response *Response) {
+	completion := map[string]interface{}{
+		"id":      "llmtest",
+		"object":  "chat.completion",
+		"model":   model,
+		"choices": []interface{}{},
+	}
+	if !response.EmptyChoices {
+		completion["choices"] = []interface{}{
+			map[string]interface{}{"index": 0, "message": message(function, response)},
+		}
+	}
+	if response.Usage != nil {
+		completion["usage"] = response.Usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(completion)
+}
+
+func message(function string, response *Response) map[string]interface{} {
+	if response.Arguments == "" {
+		return map[string]interface{}{"role": "assistant", "content": response.Content}
+	}
+	return map[string]interface{}{
+		"role":    "assistant",
+		"content": "",
+		"tool_calls": []interface{}{
+			map[string]interface{}{
+				"id":   "call_llmtest",
+				"type": "function",
+				"function": map[string]interface{}{
+					"name":      function,
+					"arguments": response.Arguments,
+				},
+			},
+		},
+	}
+}
+
+// writeStream sends the response as server-sent events, split into a few fragments
+func writeStream(w http.ResponseWriter, function string, response *Response) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	send := func(chunk map[string]interface{}) {
+		data, _ := json.Marshal(chunk)
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if !response.EmptyChoices {
+		text := response.Content
+		if response.Arguments != "" {
+			text = response.Arguments
+		}
+		for _, fragment := range split(text, streamChunks) {
+			delta := map[string]interface{}{"content": fragment}
+			if response.Arguments != "" {
+				delta = map[string]interface{}{
+					"tool_calls": []interface{}{
+						map[string]interface{}{
+							"index":    0,
+							"function": map[string]interface{}{"name": function, "arguments": fragment},
+						},
+					},
+				}
+			}
+			send(map[string]interface{}{
+				"choices": []interface{}{map[string]interface{}{"index": 0, "delta": delta}},
+			})
+		}
+	}
+
+	if response.Usage != nil {
+		send(map[string]interface{}{"choices": []interface{}{}, "usage": response.Usage})
+	}
+
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// split cuts text into at most n fragments of similar size
+func split(text string, n int) []string {
+	runes := []rune(text)
+	size := (len(runes) + n - 1) / n
+	if size == 0 {
+		return nil
+	}
+
+	fragments := make([]string, 0, n)
+	for start := 0; start < len(runes); start += size {
+		fragments = append(fragments, string(runes[start:min(start+size, len(runes))]))
+	}
+	return fragments
+}
@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/secret"
+)
+
+// modelList is the response of OpenAI-compatible /models and Ollama's native /tags
+type modelList struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// ListModels returns the names of the models served at the configured endpoint, sorted. It
+// doubles as a connectivity check of base_url and api_key.
+func ListModels(ctx context.Context, cfg *config.LLMConfig) ([]string, error) {
+	httpClient, err := newHTTPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := "/models"
+	if cfg.Provider == ProviderOllama {
+		endpoint = "/tags"
+	}
+	url := strings.TrimSuffix(cfg.BaseURL, "/") + endpoint
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, modelsError(err, url)
+	}
+	token, err := secret.Resolve(cfg.APIKey.Reveal())
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, modelsError(err, url)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.WrapWithContext(
+			errors.CodeLLMError,
+			errors.ErrLLMStatus,
+			errors.FormatContext(errors.ContextLLMModels, url)+fmt.Sprintf(": HTTP %d", resp.StatusCode),
+		)
+	}
+
+	var list modelList
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, modelsError(err, url)
+	}
+
+	models := make([]string, 0, len(list.Data)+len(list.Models))
+	for _, model := range list.Data {
+		models = append(models, model.ID)
+	}
+	for _, model := range list.Models {
+		models = append(models, model.Name)
+	}
+	sort.Strings(models)
+
+	return models, nil
+}
+
+func modelsError(err error, url string) error {
+	return errors.WrapWithContext(
+		errors.CodeLLMError,
+		err,
+		errors.FormatContext(errors.ContextLLMModels, url),
+	)
+}
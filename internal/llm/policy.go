@@ -0,0 +1,102 @@
+package llm
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+const dialTimeout = 30 * time.Second
+
+// isLocalIP reports whether ip is a loopback, private or link-local address
+func isLocalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast()
+}
+
+// checkLocal verifies that every address the host of baseURL resolves to is local, so the
+// local-only policy fails before any request is made
+func checkLocal(ctx context.Context, baseURL string) error {
+	parsed, err := url.Parse(baseURL)
+	if err != nil || parsed.Hostname() == "" {
+		return notLocal(baseURL)
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isLocalIP(ip) {
+			return notLocal(host)
+		}
+		return nil
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			errors.FormatContext(errors.ContextLLMNotLocal, host),
+		)
+	}
+	for _, addr := range addrs {
+		if !isLocalIP(addr.IP) {
+			return notLocal(host + " (" + addr.IP.String() + ")")
+		}
+	}
+
+	return nil
+}
+
+func notLocal(host string) error {
+	return errors.WrapWithContext(
+		errors.CodeConfigError,
+		errors.ErrInvalidConfig,
+		errors.FormatContext(errors.ContextLLMNotLocal, host),
+	)
+}
+
+// localOnlyTransport returns a transport that refuses connections to non-local addresses.
+// The check runs on the address actually dialed, so it also covers redirects and hosts that
+// resolve differently after startup. Proxies are never used, as a proxy on a local address
+// would pass the check while forwarding requests off-host.
+func localOnlyTransport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isLocalIP(ip) {
+				return notLocal(host)
+			}
+			return nil
+		},
+	}
+
+	transport, _ := http.DefaultTransport.(*http.Transport)
+	transport = transport.Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return transport
+}
+
+// newHTTPClient creates the HTTP client for cfg, enforcing the local-only policy if set
+func newHTTPClient(cfg *config.LLMConfig) (*http.Client, error) {
+	client := &http.Client{Timeout: cfg.RequestTimeout}
+	if cfg.Mode != config.ModeLocalOnly {
+		return client, nil
+	}
+
+	if err := checkLocal(context.Background(), cfg.BaseURL); err != nil {
+		return nil, err
+	}
+	client.Transport = localOnlyTransport()
+
+	return client, nil
+}
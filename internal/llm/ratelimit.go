@@ -1,9 +1,12 @@
 package llm
 
 import (
+	"context"
+	"math/rand/v2"
 	"sync"
 	"time"
 
+	"codeberg.org/mutker/bumpa/internal/config"
 	"codeberg.org/mutker/bumpa/internal/errors"
 	"codeberg.org/mutker/bumpa/internal/logger"
 )
@@ -18,11 +21,13 @@ const (
 )
 
 const (
-	defaultRetryDuration = 5 * time.Second
-	tokenSizeMultiplier  = 4 // Approximate bytes-to-tokens ratio
+	rateLimitWindow    = time.Minute
+	backoffJitterRatio = 0.5 // Up to 50% random jitter added to each backoff
+	unknownLimit       = -1  // Limit not reported by the provider
 )
 
-// RateLimiter handles rate limiting for LLM API calls
+// RateLimiter throttles LLM API calls using the limits reported in response headers and
+// optional client-side requests/tokens per minute limits
 type RateLimiter struct {
 	mu sync.Mutex
 
@@ -36,9 +41,26 @@ type RateLimiter struct {
 
 	// Last update time for rate limit info
 	lastUpdate time.Time
+
+	// Client-side limits for providers that don't send rate limit headers
+	requestsPerMinute int
+	tokensPerMinute   int
+	window            []windowEntry
+
+	// Retry policy for 429 responses
+	maxRetries     int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
 }
 
-// RateLimitInfo contains rate limit information from API headers
+// windowEntry records a request made within the client-side rate limit window
+type windowEntry struct {
+	at     time.Time
+	tokens int
+}
+
+// RateLimitInfo contains rate limit information from API headers. Fields are -1 when the
+// provider didn't send the corresponding header.
 type RateLimitInfo struct {
 	RemainingTokens   int
 	TokensResetIn     time.Duration
@@ -47,20 +69,17 @@ type RateLimitInfo struct {
 	RetryAfter        time.Duration // Only set when receiving 429
 }
 
-// WaitInfo contains information about why we're waiting
-type WaitInfo struct {
-	NeedsToWait     bool
-	RemainingTokens int
-	WaitTime        time.Duration
-	ResetAt         time.Time
-}
-
 // NewRateLimiter creates a new rate limiter instance
-func NewRateLimiter() *RateLimiter {
+func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
 	return &RateLimiter{
-		remainingTokens:   -1, // -1 indicates not yet initialized
-		remainingRequests: -1,
+		remainingTokens:   unknownLimit,
+		remainingRequests: unknownLimit,
 		lastUpdate:        time.Now(),
+		requestsPerMinute: cfg.RequestsPerMinute,
+		tokensPerMinute:   cfg.TokensPerMinute,
+		maxRetries:        cfg.MaxRetries,
+		initialBackoff:    cfg.InitialBackoff,
+		maxBackoff:        cfg.MaxBackoff,
 	}
 }
 
@@ -72,32 +91,141 @@ func (rl *RateLimiter) UpdateLimits(info RateLimitInfo) {
 	now := time.Now()
 	rl.lastUpdate = now
 
-	rl.remainingTokens = info.RemainingTokens
-	rl.tokensResetAt = now.Add(info.TokensResetIn)
+	if info.RemainingTokens != unknownLimit {
+		rl.remainingTokens = info.RemainingTokens
+		rl.tokensResetAt = now.Add(info.TokensResetIn)
+	}
 
-	rl.remainingRequests = info.RemainingRequests
-	rl.requestsResetAt = now.Add(info.RequestsResetIn)
+	if info.RemainingRequests != unknownLimit {
+		rl.remainingRequests = info.RemainingRequests
+		rl.requestsResetAt = now.Add(info.RequestsResetIn)
+	}
+
+	// A 429 means no capacity is left until the server says otherwise
+	if info.RetryAfter > 0 {
+		rl.remainingRequests = 0
+		rl.requestsResetAt = now.Add(info.RetryAfter)
+	}
 }
 
-// WaitForCapacity waits until there's capacity to make a request
-func (rl *RateLimiter) WaitForCapacity() {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+// WaitForCapacity blocks until a request of estimatedTokens fits within the known limits,
+// then reserves capacity for it. It returns early if ctx is cancelled.
+func (rl *RateLimiter) WaitForCapacity(ctx context.Context, estimatedTokens int) error {
+	for {
+		rl.mu.Lock()
+		now := time.Now()
+		wait, reason := rl.waitTime(now, estimatedTokens)
+		if wait <= 0 {
+			rl.reserve(now, estimatedTokens)
+			rl.mu.Unlock()
+			return nil
+		}
+		remainingTokens := rl.remainingTokens
+		remainingRequests := rl.remainingRequests
+		rl.mu.Unlock()
+
+		logger.Warn().
+			Str("reason", reason).
+			Int("estimated_tokens", estimatedTokens).
+			Int("remaining_tokens", remainingTokens).
+			Int("remaining_requests", remainingRequests).
+			Dur("wait", wait).
+			Msg("Rate limit reached, waiting for capacity")
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return errors.WrapWithContext(
+				errors.CodeTimeoutError,
+				err,
+				errors.ContextLLMRateLimit,
+			)
+		}
+	}
+}
+
+// waitTime returns how long to wait before a request of tokens may be sent. Must be called
+// with the lock held.
+func (rl *RateLimiter) waitTime(now time.Time, tokens int) (time.Duration, string) {
+	// Limits reported by the provider expire at their reset time
+	if rl.remainingRequests != unknownLimit && !now.Before(rl.requestsResetAt) {
+		rl.remainingRequests = unknownLimit
+	}
+	if rl.remainingTokens != unknownLimit && !now.Before(rl.tokensResetAt) {
+		rl.remainingTokens = unknownLimit
+	}
+
+	if rl.remainingRequests == 0 {
+		return rl.requestsResetAt.Sub(now), "requests"
+	}
+	if rl.remainingTokens != unknownLimit && rl.remainingTokens < tokens {
+		return rl.tokensResetAt.Sub(now), "tokens"
+	}
 
-	if rl.remainingTokens >= 0 {
-		logger.Debug().
-			Int("remaining_tokens", rl.remainingTokens).
-			Int("remaining_requests", rl.remainingRequests).
-			Time("tokens_reset_at", rl.tokensResetAt).
-			Time("requests_reset_at", rl.requestsResetAt).
-			Msg("Current rate limit status")
+	// Client-side sliding window
+	cutoff := now.Add(-rateLimitWindow)
+	for len(rl.window) > 0 && !rl.window[0].at.After(cutoff) {
+		rl.window = rl.window[1:]
+	}
+	if len(rl.window) == 0 {
+		return 0, ""
+	}
+
+	oldestExpires := rl.window[0].at.Add(rateLimitWindow).Sub(now)
+	if rl.requestsPerMinute > 0 && len(rl.window) >= rl.requestsPerMinute {
+		return oldestExpires, "requests_per_minute"
+	}
+	if rl.tokensPerMinute > 0 {
+		used := 0
+		for _, entry := range rl.window {
+			used += entry.tokens
+		}
+		if used+tokens > rl.tokensPerMinute {
+			return oldestExpires, "tokens_per_minute"
+		}
 	}
+
+	return 0, ""
 }
 
-// HandleRetryAfter handles 429 responses by waiting for the specified duration
-func HandleRetryAfter(retryAfter time.Duration) {
-	logger.Debug().
-		Float64("retry_after_seconds", retryAfter.Seconds()).
-		Msg(errors.ContextLLMRateLimit)
-	time.Sleep(retryAfter)
+// reserve accounts for a request about to be sent. Must be called with the lock held.
+func (rl *RateLimiter) reserve(now time.Time, tokens int) {
+	if rl.remainingRequests > 0 {
+		rl.remainingRequests--
+	}
+	if rl.remainingTokens != unknownLimit {
+		rl.remainingTokens = max(rl.remainingTokens-tokens, 0)
+	}
+	if rl.requestsPerMinute > 0 || rl.tokensPerMinute > 0 {
+		rl.window = append(rl.window, windowEntry{at: now, tokens: tokens})
+	}
+}
+
+// Backoff returns how long to wait before retry attempt (starting at 1) after a 429 response,
+// and false once the retry budget is exhausted. The delay grows exponentially with jitter up
+// to the maximum backoff, and is never shorter than the server's Retry-After.
+func (rl *RateLimiter) Backoff(attempt int, retryAfter time.Duration) (time.Duration, bool) {
+	if attempt > rl.maxRetries {
+		return 0, false
+	}
+
+	delay := rl.initialBackoff << (attempt - 1)
+	if delay <= 0 || delay > rl.maxBackoff {
+		delay = rl.maxBackoff
+	}
+	delay += time.Duration(rand.Float64() * backoffJitterRatio * float64(delay)) //nolint:gosec // Jitter doesn't need crypto randomness
+	delay = min(delay, rl.maxBackoff)
+
+	return max(delay, retryAfter), true
+}
+
+// sleepContext waits for d or until ctx is cancelled
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"encoding/json"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+// FunctionResult holds the decoded tool call arguments returned for a function
+type FunctionResult struct {
+	Name      string
+	Arguments map[string]interface{}
+}
+
+// String returns the string argument for key, or an empty string if missing or not a string
+func (r *FunctionResult) String(key string) string {
+	if r == nil {
+		return ""
+	}
+	if value, ok := r.Arguments[key].(string); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+// Bool returns the boolean argument for key, or false if missing or not a boolean
+func (r *FunctionResult) Bool(key string) bool {
+	if r == nil {
+		return false
+	}
+	value, ok := r.Arguments[key].(bool)
+	return ok && value
+}
+
+// JoinStrings joins the non-empty string arguments for key across results, used to combine
+// the results of a function called once per chunk of its input
+func JoinStrings(results []*FunctionResult, key, sep string) string {
+	values := make([]string, 0, len(results))
+	for _, result := range results {
+		if value := result.String(key); value != "" {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, sep)
+}
+
+// decodeFunctionResult decodes the raw tool call arguments against the function's output schema.
+// Models that ignore tool_choice and answer in plain text are accepted when the schema has a
+// single string property, which then receives the cleaned text.
+func decodeFunctionResult(fn *config.LLMFunction, response string) (*FunctionResult, error) {
+	response = strings.TrimSpace(response)
+	if response == "" {
+		return nil, errors.WrapWithContext(
+			errors.CodeLLMError,
+			errors.ErrInvalidResponse,
+			errors.ContextLLMEmptyResponse,
+		)
+	}
+
+	var arguments map[string]interface{}
+	if err := json.Unmarshal([]byte(response), &arguments); err != nil {
+		key, ok := singleStringProperty(&fn.Output)
+		if !ok {
+			return nil, &invalidOutputError{
+				function: fn.Name,
+				response: response,
+				violations: SchemaViolations{
+					{Path: "$", Message: "must be a JSON object with the function arguments"},
+				},
+			}
+		}
+
+		logger.Debug().
+			Str("function", fn.Name).
+			Str("property", key).
+			Msg("Model answered without a tool call, using content as the single output property")
+
+		arguments = map[string]interface{}{key: cleanResponse(response)}
+	}
+
+	if violations := ValidateSchema(&fn.Output, arguments); len(violations) > 0 {
+		return nil, &invalidOutputError{
+			function:   fn.Name,
+			response:   response,
+			violations: violations,
+		}
+	}
+
+	return &FunctionResult{
+		Name:      fn.Name,
+		Arguments: arguments,
+	}, nil
+}
+
+// invalidOutputError reports model output that doesn't satisfy the function's output schema.
+// It carries the raw response and violations so the caller can ask the model to repair it.
+type invalidOutputError struct {
+	function   string
+	response   string
+	violations SchemaViolations
+}
+
+func (e *invalidOutputError) Error() string {
+	return errors.WrapWithContext(
+		errors.CodeLLMError,
+		errors.ErrInvalidResponse,
+		errors.FormatContext(errors.ContextLLMInvalidArgs, e.function, e.violations.Error()),
+	).Error()
+}
+
+func (*invalidOutputError) Unwrap() error {
+	return errors.ErrInvalidResponse
+}
+
+// singleStringProperty returns the property name if the schema consists of exactly one string property
+func singleStringProperty(schema *config.FunctionParameters) (string, bool) {
+	if len(schema.Properties) != 1 {
+		return "", false
+	}
+	for name, prop := range schema.Properties {
+		if prop.Type == "string" && len(prop.Enum) == 0 {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+)
+
+// SchemaViolation describes a single mismatch between a value and its declared schema
+type SchemaViolation struct {
+	Path    string
+	Message string
+}
+
+func (v SchemaViolation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// SchemaViolations is the list of violations found while validating a value
+type SchemaViolations []SchemaViolation
+
+func (v SchemaViolations) Error() string {
+	messages := make([]string, len(v))
+	for i := range v {
+		messages[i] = v[i].String()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// ValidateSchema validates decoded JSON arguments against a function output schema and
+// returns every violation found, or nil if the arguments are valid
+func ValidateSchema(schema *config.FunctionParameters, arguments map[string]interface{}) SchemaViolations {
+	var violations SchemaViolations
+
+	for _, name := range schema.Required {
+		value, ok := arguments[name]
+		if !ok || value == nil {
+			violations = append(violations, SchemaViolation{Path: "$." + name, Message: "is required"})
+			continue
+		}
+		if str, isString := value.(string); isString && strings.TrimSpace(str) == "" {
+			violations = append(violations, SchemaViolation{Path: "$." + name, Message: "must not be empty"})
+		}
+	}
+
+	// Iterate in sorted order so violations (and the repair prompt) are deterministic
+	names := make([]string, 0, len(arguments))
+	for name := range arguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		prop, ok := schema.Properties[name]
+		if !ok {
+			continue
+		}
+		violations = validateProperty(&prop, arguments[name], "$."+name, violations)
+	}
+
+	return violations
+}
+
+func validateProperty(prop *config.Property, value interface{}, path string, violations SchemaViolations) SchemaViolations {
+	if value == nil {
+		return violations
+	}
+
+	if !matchesType(prop.Type, value) {
+		return append(violations, SchemaViolation{
+			Path:    path,
+			Message: fmt.Sprintf("must be of type %s (got: %s)", prop.Type, jsonTypeOf(value)),
+		})
+	}
+
+	if len(prop.Enum) > 0 {
+		if str, _ := value.(string); !slices.Contains(prop.Enum, str) {
+			violations = append(violations, SchemaViolation{
+				Path:    path,
+				Message: fmt.Sprintf("must be one of [%s] (got: %v)", strings.Join(prop.Enum, ", "), value),
+			})
+		}
+	}
+
+	if items, ok := value.([]interface{}); ok && prop.Items != nil {
+		for i, item := range items {
+			violations = validateProperty(prop.Items, item, fmt.Sprintf("%s[%d]", path, i), violations)
+		}
+	}
+
+	return violations
+}
+
+func matchesType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	default:
+		return true
+	}
+}
+
+func jsonTypeOf(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return "null"
+	}
+}
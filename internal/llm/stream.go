@@ -0,0 +1,332 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	sseDataPrefix      = "data:"
+	sseDoneMarker      = "[DONE]"
+	maxStreamLineBytes = 1024 * 1024
+)
+
+// StreamHandler receives response fragments as they arrive. It is called a final time with
+// done set once the response is complete.
+type StreamHandler func(fragment string, done bool)
+
+type streamHandlerKey struct{}
+
+// WithStreamHandler returns a context that makes CallFunction stream responses to handler
+// when the client supports streaming
+func WithStreamHandler(ctx context.Context, handler StreamHandler) context.Context {
+	return context.WithValue(ctx, streamHandlerKey{}, handler)
+}
+
+func streamHandlerFrom(ctx context.Context) StreamHandler {
+	handler, _ := ctx.Value(streamHandlerKey{}).(StreamHandler)
+	return handler
+}
+
+// FieldPreview wraps handler so that it receives the text of the string field as it is streamed
+// when the model answers with tool arguments, rather than the JSON around it. Plain content is
+// passed through unchanged.
+func FieldPreview(field string, handler StreamHandler) StreamHandler {
+	key := regexp.MustCompile(`(?s)^\s*\{.*?"` + regexp.QuoteMeta(field) + `"\s*:\s*"`)
+	var received strings.Builder
+	var shown int
+
+	return func(fragment string, done bool) {
+		if done {
+			received.Reset()
+			shown = 0
+			handler("", true)
+			return
+		}
+
+		received.WriteString(fragment)
+		text := received.String()
+		if trimmed := strings.TrimSpace(text); trimmed == "" {
+			return
+		} else if trimmed[0] == '{' {
+			loc := key.FindStringIndex(text)
+			if loc == nil {
+				return // The field has not arrived yet
+			}
+			text = decodePartialString(text[loc[1]:])
+		}
+
+		if len(text) > shown {
+			handler(text[shown:], false)
+			shown = len(text)
+		}
+	}
+}
+
+// decodePartialString decodes the JSON string body at the start of s up to its closing quote,
+// or up to the last complete character if the string is still being streamed
+func decodePartialString(s string) string {
+	var decoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return decoded.String()
+		case '\\':
+			length := 2
+			if i+1 < len(s) && s[i+1] == 'u' {
+				length = 6
+			}
+			if i+length > len(s) {
+				return decoded.String()
+			}
+			var char string
+			if json.Unmarshal([]byte(`"`+s[i:i+length]+`"`), &char) != nil {
+				return decoded.String()
+			}
+			decoded.WriteString(char)
+			i += length - 1
+		default:
+			decoded.WriteByte(s[i])
+		}
+	}
+	return decoded.String()
+}
+
+// StreamChunk is a single streamed event. OpenAI-compatible servers send choice deltas as
+// server-sent events, while Ollama's native API sends whole messages as NDJSON lines.
+type StreamChunk struct {
+	Choices         []StreamChoice `json:"choices"`
+	Message         *StreamMessage `json:"message,omitempty"`
+	Done            bool           `json:"done,omitempty"`
+	Usage           *Usage         `json:"usage,omitempty"`             // Final chunk with include_usage
+	PromptEvalCount int            `json:"prompt_eval_count,omitempty"` //nolint:tagliatelle // Ollama native API
+	EvalCount       int            `json:"eval_count,omitempty"`        //nolint:tagliatelle // Ollama native API
+	Error           *APIError      `json:"error,omitempty"`
+}
+
+type StreamChoice struct {
+	Delta        StreamMessage `json:"delta"`
+	FinishReason string        `json:"finish_reason,omitempty"` //nolint:tagliatelle // Following OpenAI API spec
+}
+
+type StreamMessage struct {
+	Content   string           `json:"content"`
+	ToolCalls []StreamToolCall `json:"tool_calls,omitempty"` //nolint:tagliatelle // Following OpenAI API spec
+}
+
+type StreamToolCall struct {
+	Function struct {
+		Name string `json:"name,omitempty"`
+		// Arguments is a string fragment for OpenAI-compatible servers and a JSON object for Ollama
+		Arguments json.RawMessage `json:"arguments,omitempty"`
+	} `json:"function"`
+}
+
+// StreamText sends the request with streaming enabled and passes each content or tool argument
+// fragment to handler. The assembled response is returned in the same form as GenerateText.
+func (c *OpenAIClient) StreamText(
+	ctx context.Context,
+	systemPrompt, userPrompt string,
+	apiFunctions []APIFunction,
+	handler StreamHandler,
+) (string, error) {
+	if ctx == nil {
+		return "", errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidInput,
+			"context cannot be nil",
+		)
+	}
+
+	request := c.buildRequest(systemPrompt, userPrompt, apiFunctions)
+	request.Stream = true
+	// Only streamed requests ask for usage, other responses always carry it
+	request.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	logger.Debug().
+		Int("message_count", len(request.Messages)).
+		Int("function_count", len(request.Functions)).
+		Str("model", c.model).
+		Msg("Preparing streaming LLM request")
+
+	requestJSON, err := json.Marshal(&request)
+	if err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			"failed to marshal request",
+		)
+	}
+
+	started := time.Now()
+	resp, err := c.sendRequest(ctx, requestJSON)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	// The exchange is recorded even when the stream fails after reporting usage
+	content, usage, err := readStream(ctx, resp.Body, handler)
+	if handler != nil {
+		handler("", true)
+	}
+	if err == nil || usage != nil {
+		c.recordUsage(ctx, apiFunctions, usage, requestJSON, content, started)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return content, nil
+}
+
+// readStream consumes SSE or NDJSON events until the stream ends, returning the tool call
+// arguments if the model called a tool and the plain content otherwise, along with the usage
+// if the server reported it, which is also returned when the stream fails
+func readStream(ctx context.Context, body io.Reader, handler StreamHandler) (string, *Usage, error) {
+	var content, arguments strings.Builder
+	var usage *Usage
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxStreamLineBytes)
+
+	for scanner.Scan() {
+		if ctx.Err() != nil {
+			return "", usage, errors.WrapWithContext(
+				errors.CodeTimeoutError,
+				ctx.Err(),
+				errors.ContextLLMTimeout,
+			)
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ":") {
+			continue // Blank separators and SSE comments
+		}
+
+		line = strings.TrimSpace(strings.TrimPrefix(line, sseDataPrefix))
+		if line == sseDoneMarker {
+			break
+		}
+
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return "", usage, errors.WrapWithContext(
+				errors.CodeLLMError,
+				err,
+				errors.ContextLLMResponse,
+			)
+		}
+
+		if chunk.Error != nil {
+			return "", usage, errors.WrapWithContext(
+				errors.CodeLLMError,
+				errors.ErrLLMStatus,
+				chunk.Error.Message,
+			)
+		}
+
+		for _, message := range chunk.messages() {
+			emit(&content, message.Content, handler)
+			for _, call := range message.ToolCalls {
+				emit(&arguments, decodeArgumentsFragment(call.Function.Arguments), handler)
+			}
+		}
+
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		} else if chunk.PromptEvalCount > 0 || chunk.EvalCount > 0 {
+			usage = &Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+
+		if chunk.Done {
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		if ctx.Err() != nil {
+			return "", usage, errors.WrapWithContext(
+				errors.CodeTimeoutError,
+				ctx.Err(),
+				errors.ContextLLMTimeout,
+			)
+		}
+		return "", usage, errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			errors.ContextLLMResponse,
+		)
+	}
+
+	if arguments.Len() > 0 {
+		return arguments.String(), usage, nil
+	}
+	if content.Len() > 0 {
+		return content.String(), usage, nil
+	}
+
+	return "", usage, errors.WrapWithContext(
+		errors.CodeLLMError,
+		errors.ErrInvalidResponse,
+		errors.ContextLLMEmptyResponse,
+	)
+}
+
+// messages returns the message parts carried by the chunk in either stream format
+func (c *StreamChunk) messages() []StreamMessage {
+	if c.Message != nil {
+		return []StreamMessage{*c.Message}
+	}
+	messages := make([]StreamMessage, 0, len(c.Choices))
+	for _, choice := range c.Choices {
+		messages = append(messages, choice.Delta)
+	}
+	return messages
+}
+
+func emit(builder *strings.Builder, fragment string, handler StreamHandler) {
+	if fragment == "" {
+		return
+	}
+	builder.WriteString(fragment)
+	if handler != nil {
+		handler(fragment, false)
+	}
+}
+
+// decodeArgumentsFragment returns tool arguments as text whether they were sent as a JSON
+// string fragment or as a complete JSON object
+func decodeArgumentsFragment(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var fragment string
+	if err := json.Unmarshal(raw, &fragment); err == nil {
+		return fragment
+	}
+	return string(raw)
+}
+
+// generate sends the prompts through the streaming path when the context carries a stream
+// handler and the client supports it, and through GenerateText otherwise
+func generate(ctx context.Context, client Client, systemPrompt, userPrompt string, functions []APIFunction) (string, error) {
+	if handler := streamHandlerFrom(ctx); handler != nil {
+		if streamer, ok := client.(StreamingClient); ok {
+			return streamer.StreamText(ctx, systemPrompt, userPrompt, functions, handler)
+		}
+	}
+	return client.GenerateText(ctx, systemPrompt, userPrompt, functions)
+}
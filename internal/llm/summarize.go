@@ -0,0 +1,279 @@
+package llm
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	reduceScopeField     = "scope"
+	reduceSummariesField = "summaries"
+	reduceSummaryField   = "summary"
+	rootDirectory        = "./"
+)
+
+// Summarizer produces file summaries that fit the model's context window and reduces them
+// map-reduce style: chunk summaries into a file summary, and file summaries into directory
+// summaries when a change touches many files
+type Summarizer struct {
+	client      Client
+	budget      *Budget
+	reduce      *config.LLMFunction
+	maxRetries  int
+	concurrency int
+	dirLimit    int
+}
+
+// NewSummarizer creates a summarizer for the configured model. Reduction is disabled when
+// the reduce_summaries function isn't configured.
+func NewSummarizer(client Client, cfg *config.Config) *Summarizer {
+	return &Summarizer{
+		client:      client,
+		budget:      NewBudget(&cfg.LLM),
+		reduce:      config.FindFunction(cfg.Functions, config.ReduceFunction),
+		maxRetries:  cfg.LLM.MaxRetries,
+		concurrency: cfg.LLM.Concurrency,
+		dirLimit:    cfg.LLM.DirectoryLimit,
+	}
+}
+
+// SummarizeFile calls fn for a file whose input[field] is made up of parts, and returns the
+// string output key. Diffs that need several calls are reduced into one summary with the
+// summarize overflow strategy and joined otherwise.
+func (s *Summarizer) SummarizeFile(
+	ctx context.Context,
+	fn *config.LLMFunction,
+	input map[string]interface{},
+	field string,
+	parts []string,
+	key string,
+) (string, error) {
+	results, err := CallFunctionFitted(ctx, s.client, s.budget, fn, input, field, parts, s.maxRetries)
+	if err != nil {
+		return "", err
+	}
+	if len(results) == 1 || s.budget.overflow != config.OverflowSummarize || s.reduce == nil {
+		return JoinStrings(results, key, "; "), nil
+	}
+
+	summaries := make([]string, 0, len(results))
+	for _, result := range results {
+		summaries = append(summaries, result.String(key))
+	}
+
+	file, _ := input["file"].(string)
+	return s.Reduce(ctx, "file "+file, summaries)
+}
+
+// FileSummaryInput builds the generate_file_summary input for a changed file. Commit and version
+// build it the same way, so that a summary cached by one command is found by the other.
+func FileSummaryInput(file, status, diff string) map[string]interface{} {
+	filtered, significant := filterImportChanges(diff)
+	return map[string]interface{}{
+		"file":                  file,
+		"status":                status,
+		"diff":                  filtered,
+		"hasSignificantChanges": significant,
+	}
+}
+
+// filterImportChanges drops the unchanged lines of Go import blocks from diff and reports
+// whether it adds or removes any lines
+func filterImportChanges(diff string) (string, bool) {
+	lines := strings.Split(diff, "\n")
+	var filteredLines []string
+	inImportBlock := false
+	significantChanges := false
+
+	for _, line := range lines {
+		if strings.HasPrefix(line, "import (") {
+			inImportBlock = true
+		} else if inImportBlock && strings.HasPrefix(line, ")") {
+			inImportBlock = false
+		}
+
+		if inImportBlock {
+			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+				filteredLines = append(filteredLines, line)
+				significantChanges = true
+			}
+		} else {
+			filteredLines = append(filteredLines, line)
+			if strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") {
+				significantChanges = true
+			}
+		}
+	}
+
+	return strings.Join(filteredLines, "\n"), significantChanges
+}
+
+// Reduce combines summaries into a single summary of scope. Summaries that don't fit in one
+// request are reduced in chunks, and the chunk results again, until one summary is left.
+func (s *Summarizer) Reduce(ctx context.Context, scope string, summaries []string) (string, error) {
+	if s.reduce == nil {
+		return strings.Join(summaries, "; "), nil
+	}
+
+	input := map[string]interface{}{
+		reduceScopeField:     scope,
+		reduceSummariesField: "",
+	}
+	available, err := s.budget.Available(s.reduce, input, reduceSummariesField)
+	if err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeTemplateError,
+			err,
+			"failed to render prompts for token budget",
+		)
+	}
+	if available <= 0 {
+		return "", errors.WrapWithContext(
+			errors.CodeLLMError,
+			errors.ErrInvalidInput,
+			errors.FormatContext(errors.ContextLLMContextWindow, s.reduce.Name, s.budget.window),
+		)
+	}
+
+	for len(summaries) > 1 {
+		parts := make([]string, 0, len(summaries))
+		for _, summary := range summaries {
+			parts = append(parts, "- "+summary+"\n")
+		}
+
+		chunks := s.budget.chunk(parts, available)
+		if len(chunks) == len(parts) {
+			// Every summary fills the budget on its own, keep what fits in a single request
+			kept, dropped, err := s.budget.truncate(parts, available)
+			if err != nil {
+				return "", err
+			}
+			chunks = []string{kept}
+			logger.Warn().
+				Str("scope", scope).
+				Int("dropped", dropped).
+				Int("summaries", len(parts)).
+				Msg("Summaries too large to reduce together, dropping those that do not fit")
+		}
+
+		logger.Debug().
+			Str("scope", scope).
+			Int("summaries", len(summaries)).
+			Int("chunks", len(chunks)).
+			Msg("Reducing summaries")
+
+		summaries, err = RunConcurrent(ctx, s.concurrency, chunks,
+			func(ctx context.Context, chunk string) (string, error) {
+				result, err := CallFunction(ctx, s.client, s.reduce,
+					withField(input, reduceSummariesField, chunk), s.maxRetries)
+				if err != nil {
+					return "", err
+				}
+				return result.String(reduceSummaryField), nil
+			},
+		)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if len(summaries) == 0 {
+		return "", nil
+	}
+	return summaries[0], nil
+}
+
+// ReduceDirectories folds file summaries into directory summaries, deepest directories first,
+// until at most the configured directory limit of entries is left. Directory keys end in a
+// slash, the repository root is "./". Summaries are returned unchanged below the limit.
+func (s *Summarizer) ReduceDirectories(ctx context.Context, summaries map[string]string) (map[string]string, error) {
+	if s.reduce == nil || s.dirLimit <= 0 || len(summaries) <= s.dirLimit {
+		return summaries, nil
+	}
+
+	logger.Info().
+		Int("files", len(summaries)).
+		Int("limit", s.dirLimit).
+		Msg("Reducing file summaries per directory")
+
+	current := make(map[string]string, len(summaries))
+	for key, summary := range summaries {
+		current[key] = summary
+	}
+
+	for len(current) > s.dirLimit {
+		deepest := 0
+		for key := range current {
+			deepest = max(deepest, pathDepth(key))
+		}
+		if deepest == 0 {
+			break
+		}
+
+		// Group the deepest entries by their parent directory
+		groups := make(map[string][]string)
+		for key := range current {
+			if pathDepth(key) == deepest {
+				parent := parentDirectory(key)
+				groups[parent] = append(groups[parent], key)
+			}
+		}
+
+		parents := make([]string, 0, len(groups))
+		for parent := range groups {
+			parents = append(parents, parent)
+		}
+		sort.Strings(parents)
+
+		reduced, err := RunConcurrent(ctx, s.concurrency, parents,
+			func(ctx context.Context, parent string) (string, error) {
+				keys := groups[parent]
+				sort.Strings(keys)
+
+				lines := make([]string, 0, len(keys))
+				for _, key := range keys {
+					lines = append(lines, strings.TrimPrefix(key, parent)+": "+current[key])
+				}
+				if len(lines) == 1 {
+					return lines[0], nil
+				}
+				return s.Reduce(ctx, "directory "+parent, lines)
+			},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, parent := range parents {
+			for _, key := range groups[parent] {
+				delete(current, key)
+			}
+			current[parent] = reduced[i]
+		}
+	}
+
+	return current, nil
+}
+
+// pathDepth returns the number of path segments in a summary key, zero for the root
+func pathDepth(key string) int {
+	if key == rootDirectory {
+		return 0
+	}
+	return strings.Count(strings.TrimSuffix(key, "/"), "/") + 1
+}
+
+// parentDirectory returns the directory key containing a file or directory key
+func parentDirectory(key string) string {
+	dir := path.Dir(strings.TrimSuffix(key, "/"))
+	if dir == "." {
+		return rootDirectory
+	}
+	return dir + "/"
+}
@@ -0,0 +1,195 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/base64"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	encodingCL100K = "cl100k_base"
+	encodingO200K  = "o200k_base"
+
+	encodingFileSuffix = ".tiktoken"
+	bytesPerTokenGuess = 4 // Average bytes per BPE token for words in code and English text
+)
+
+//go:embed encodings
+var encodingFiles embed.FS
+
+// preTokenizer approximates the tiktoken cl100k/o200k split pattern. Go's regexp has no
+// lookahead, so trailing whitespace runs are kept as a single piece.
+var preTokenizer = regexp.MustCompile(
+	`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`,
+)
+
+// modelEncodings maps model name prefixes to their BPE encoding, longest prefix first
+var modelEncodings = []struct {
+	prefix   string
+	encoding string
+}{
+	{"gpt-4o", encodingO200K},
+	{"gpt-4.1", encodingO200K},
+	{"o1", encodingO200K},
+	{"o3", encodingO200K},
+	{"o4", encodingO200K},
+	{"gpt-4", encodingCL100K},
+	{"gpt-3.5", encodingCL100K},
+	{"text-embedding-3", encodingCL100K},
+}
+
+// Tokenizer counts the tokens a model needs for a piece of text
+type Tokenizer interface {
+	Count(text string) int
+	Name() string
+}
+
+var (
+	tokenizersMu sync.Mutex
+	tokenizers   = map[string]Tokenizer{}
+)
+
+// NewTokenizer returns the tokenizer for model, using an embedded BPE table when one is
+// available for the model family and a heuristic estimate otherwise
+func NewTokenizer(model string) Tokenizer {
+	encoding := encodingForModel(model)
+	if encoding == "" {
+		return heuristicTokenizer{}
+	}
+
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+
+	if tokenizer, ok := tokenizers[encoding]; ok {
+		return tokenizer
+	}
+
+	var tokenizer Tokenizer = heuristicTokenizer{}
+	if ranks, err := loadEncoding(encoding); err == nil {
+		tokenizer = &bpeTokenizer{name: encoding, ranks: ranks}
+	} else {
+		logger.Debug().
+			Str("model", model).
+			Str("encoding", encoding).
+			Msg("BPE table not embedded, using heuristic token counts")
+	}
+	tokenizers[encoding] = tokenizer
+
+	return tokenizer
+}
+
+func encodingForModel(model string) string {
+	// Strip provider prefixes like "openai/gpt-4o"
+	if idx := strings.LastIndex(model, "/"); idx != -1 {
+		model = model[idx+1:]
+	}
+	model = strings.ToLower(model)
+
+	for _, entry := range modelEncodings {
+		if strings.HasPrefix(model, entry.prefix) {
+			return entry.encoding
+		}
+	}
+	return ""
+}
+
+// loadEncoding reads an embedded tiktoken rank table
+func loadEncoding(name string) (map[string]int, error) {
+	data, err := encodingFiles.ReadFile("encodings/" + name + encodingFileSuffix)
+	if err != nil {
+		return nil, err
+	}
+	return parseEncoding(data)
+}
+
+// parseEncoding parses a tiktoken rank table, one base64 token and its rank per line
+func parseEncoding(data []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != splitPartsExpected {
+			continue
+		}
+		token, err := base64.StdEncoding.DecodeString(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		rank, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(token)] = rank
+	}
+
+	return ranks, scanner.Err()
+}
+
+// heuristicTokenizer estimates tokens from the pre-tokenized pieces of the text
+type heuristicTokenizer struct{}
+
+func (heuristicTokenizer) Name() string {
+	return "heuristic"
+}
+
+func (heuristicTokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range preTokenizer.FindAllString(text, -1) {
+		count += int(math.Ceil(float64(len(piece)) / bytesPerTokenGuess))
+	}
+	return count
+}
+
+// bpeTokenizer counts tokens with byte-pair encoding over a tiktoken rank table
+type bpeTokenizer struct {
+	name  string
+	ranks map[string]int
+}
+
+func (t *bpeTokenizer) Name() string {
+	return t.name
+}
+
+func (t *bpeTokenizer) Count(text string) int {
+	count := 0
+	for _, piece := range preTokenizer.FindAllString(text, -1) {
+		if _, ok := t.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += t.mergeCount([]byte(piece))
+	}
+	return count
+}
+
+// mergeCount applies the lowest-ranked merges until none apply and returns the number of parts
+func (t *bpeTokenizer) mergeCount(piece []byte) int {
+	// bounds[i] is the start offset of part i; the final entry marks the end of piece
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+
+	for len(bounds) > 2 { //nolint:mnd // At least two parts are needed for a merge
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i < len(bounds)-2; i++ {
+			if rank, ok := t.ranks[string(piece[bounds[i]:bounds[i+2]])]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best == -1 {
+			break
+		}
+		bounds = append(bounds[:best+1], bounds[best+2:]...)
+	}
+
+	return len(bounds) - 1
+}
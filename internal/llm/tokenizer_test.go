@@ -0,0 +1,91 @@
+package llm
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// rankTable renders tokens as a tiktoken rank table, ranked in order
+func rankTable(tokens ...string) []byte {
+	var table strings.Builder
+	for rank, token := range tokens {
+		fmt.Fprintf(&table, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(token)), rank)
+	}
+	return []byte(table.String())
+}
+
+func TestParseEncoding(t *testing.T) {
+	ranks, err := parseEncoding(append(rankTable("a", "b", "ab"), "malformed\n\n"...))
+	if err != nil {
+		t.Fatalf("parseEncoding: %v", err)
+	}
+	want := map[string]int{"a": 0, "b": 1, "ab": 2}
+	if len(ranks) != len(want) {
+		t.Errorf("got %v, want %v", ranks, want)
+	}
+	for token, rank := range want {
+		if ranks[token] != rank {
+			t.Errorf("rank of %q is %d, want %d", token, ranks[token], rank)
+		}
+	}
+
+	if _, err := parseEncoding([]byte("not-base64! 0\n")); err == nil {
+		t.Error("got no error for an invalid token")
+	}
+}
+
+func TestBPETokenizerCount(t *testing.T) {
+	ranks, err := parseEncoding(rankTable("ab", "abc", "a", "b", "c", " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenizer := &bpeTokenizer{name: "test", ranks: ranks}
+
+	tests := map[string]int{
+		"":            0,
+		"abc":         1, // A ranked piece is a single token
+		"abcab":       2, // ab+c+ab merges into abc+ab
+		"cba":         3, // Nothing to merge
+		" abc":        2, // The leading space stays on its own
+		"abc abcab\n": 5, // abc, then space+abc+ab, then the newline
+	}
+	for text, want := range tests {
+		if got := tokenizer.Count(text); got != want {
+			t.Errorf("Count(%q) = %d, want %d", text, got, want)
+		}
+	}
+}
+
+func TestEncodingForModel(t *testing.T) {
+	tests := map[string]string{
+		"gpt-4o-mini":            encodingO200K,
+		"openai/gpt-4.1":         encodingO200K,
+		"o3-mini":                encodingO200K,
+		"gpt-4-turbo":            encodingCL100K,
+		"GPT-3.5-turbo":          encodingCL100K,
+		"text-embedding-3-small": encodingCL100K,
+		"llama3.1:latest":        "",
+	}
+	for model, want := range tests {
+		if got := encodingForModel(model); got != want {
+			t.Errorf("encodingForModel(%q) = %q, want %q", model, got, want)
+		}
+	}
+}
+
+func TestNewTokenizerFallsBackToHeuristic(t *testing.T) {
+	if name := NewTokenizer("llama3.1:latest").Name(); name != "heuristic" {
+		t.Errorf("got %s for a model without an encoding, want heuristic", name)
+	}
+
+	// Models with an encoding count exactly once its table is embedded
+	want := "heuristic"
+	if _, err := loadEncoding(encodingO200K); err == nil {
+		want = encodingO200K
+	}
+	if name := NewTokenizer("gpt-4o").Name(); name != want {
+		t.Errorf("got %s for gpt-4o, want %s", name, want)
+	}
+}
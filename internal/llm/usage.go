@@ -0,0 +1,250 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+const (
+	tokensPerPriceUnit = 1_000_000 // Prices are configured per million tokens
+	ledgerFilePerms    = 0o644
+	ledgerDirPerms     = 0o755
+	textFunctionName   = "text" // Requests made without a function
+)
+
+// Usage is the token usage reported by the provider for a single request
+type Usage struct {
+	PromptTokens     int `json:"prompt_tokens"`     //nolint:tagliatelle // Following OpenAI API spec
+	CompletionTokens int `json:"completion_tokens"` //nolint:tagliatelle // Following OpenAI API spec
+	TotalTokens      int `json:"total_tokens"`      //nolint:tagliatelle // Following OpenAI API spec
+}
+
+// FunctionUsage accumulates the usage of one function over a run
+type FunctionUsage struct {
+	Function         string        `json:"function"`
+	Calls            int           `json:"calls"`
+	CachedCalls      int           `json:"cached_calls"`      //nolint:tagliatelle // Ledger uses snake_case
+	PromptTokens     int           `json:"prompt_tokens"`     //nolint:tagliatelle // Ledger uses snake_case
+	CompletionTokens int           `json:"completion_tokens"` //nolint:tagliatelle // Ledger uses snake_case
+	Estimated        bool          `json:"estimated"`         // Provider didn't report usage for some calls
+	Latency          time.Duration `json:"latency_ns"`        //nolint:tagliatelle // Ledger uses snake_case
+	Cost             float64       `json:"cost"`
+}
+
+// LedgerEntry is the line appended to the usage ledger for each run
+type LedgerEntry struct {
+	Time      time.Time       `json:"time"`
+	Command   string          `json:"command"`
+	Model     string          `json:"model"`
+	Priced    bool            `json:"priced"` // Whether the model matched a pricing entry
+	Total     FunctionUsage   `json:"total"`
+	Functions []FunctionUsage `json:"functions"`
+}
+
+// UsageTracker aggregates token usage, latency and cost per function across a run
+type UsageTracker struct {
+	mu        sync.Mutex
+	model     string
+	price     *config.ModelPrice
+	functions map[string]*FunctionUsage
+}
+
+// NewUsageTracker creates a tracker pricing usage with the configured entry for the model
+func NewUsageTracker(cfg *config.LLMConfig) *UsageTracker {
+	return &UsageTracker{
+		model:     cfg.Model,
+		price:     findPrice(cfg.Usage.Pricing, cfg.Model),
+		functions: make(map[string]*FunctionUsage),
+	}
+}
+
+type usageTrackerKey struct{}
+
+// WithUsageTracker returns a context that makes clients record their usage in tracker
+func WithUsageTracker(ctx context.Context, tracker *UsageTracker) context.Context {
+	return context.WithValue(ctx, usageTrackerKey{}, tracker)
+}
+
+func usageTrackerFrom(ctx context.Context) *UsageTracker {
+	tracker, _ := ctx.Value(usageTrackerKey{}).(*UsageTracker)
+	return tracker
+}
+
+// findPrice returns the pricing entry with the longest model prefix matching model
+func findPrice(prices []config.ModelPrice, model string) *config.ModelPrice {
+	var best *config.ModelPrice
+	for i := range prices {
+		if !strings.HasPrefix(model, prices[i].Model) {
+			continue
+		}
+		if best == nil || len(prices[i].Model) > len(best.Model) {
+			best = &prices[i]
+		}
+	}
+	return best
+}
+
+// Priced reports whether a pricing entry matched the model
+func (t *UsageTracker) Priced() bool {
+	return t.price != nil
+}
+
+func (t *UsageTracker) entry(function string) *FunctionUsage {
+	if function == "" {
+		function = textFunctionName
+	}
+	usage, ok := t.functions[function]
+	if !ok {
+		usage = &FunctionUsage{Function: function}
+		t.functions[function] = usage
+	}
+	return usage
+}
+
+// record adds the usage of a completed request
+func (t *UsageTracker) record(function string, usage Usage, estimated bool, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := t.entry(function)
+	entry.Calls++
+	entry.PromptTokens += usage.PromptTokens
+	entry.CompletionTokens += usage.CompletionTokens
+	entry.Estimated = entry.Estimated || estimated
+	entry.Latency += latency
+	if t.price != nil {
+		entry.Cost += (float64(usage.PromptTokens)*t.price.Prompt +
+			float64(usage.CompletionTokens)*t.price.Completion) / tokensPerPriceUnit
+	}
+}
+
+// recordCacheHit counts a request answered from the response cache
+func (t *UsageTracker) recordCacheHit(function string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entry(function).CachedCalls++
+}
+
+// Functions returns the usage of each function, sorted by function name
+func (t *UsageTracker) Functions() []FunctionUsage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	functions := make([]FunctionUsage, 0, len(t.functions))
+	for _, usage := range t.functions {
+		functions = append(functions, *usage)
+	}
+	sort.Slice(functions, func(i, j int) bool {
+		return functions[i].Function < functions[j].Function
+	})
+	return functions
+}
+
+// Total returns the usage summed over all functions
+func (t *UsageTracker) Total() FunctionUsage {
+	total := FunctionUsage{Function: "total"}
+	for _, usage := range t.Functions() {
+		total.Calls += usage.Calls
+		total.CachedCalls += usage.CachedCalls
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.Estimated = total.Estimated || usage.Estimated
+		total.Latency += usage.Latency
+		total.Cost += usage.Cost
+	}
+	return total
+}
+
+// AppendLedger appends the run's usage as a JSON line to the ledger at path
+func (t *UsageTracker) AppendLedger(path, command string) error {
+	line, err := json.Marshal(LedgerEntry{
+		Time:      time.Now(),
+		Command:   command,
+		Model:     t.model,
+		Priced:    t.Priced(),
+		Total:     t.Total(),
+		Functions: t.Functions(),
+	})
+	if err != nil {
+		return errors.Wrap(errors.CodeIOError, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), ledgerDirPerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextDirCreate, filepath.Dir(path)),
+		)
+	}
+
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, ledgerFilePerms)
+	if err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, path),
+		)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, path),
+		)
+	}
+
+	return nil
+}
+
+// recordUsage records a completed request in the context's tracker, estimating token counts
+// with the client's tokenizer when the provider didn't report usage
+func (c *OpenAIClient) recordUsage(
+	ctx context.Context,
+	functions []APIFunction,
+	usage *Usage,
+	requestJSON []byte,
+	response string,
+	started time.Time,
+) {
+	tracker := usageTrackerFrom(ctx)
+	if tracker == nil {
+		return
+	}
+
+	estimated := usage == nil
+	if estimated {
+		usage = &Usage{
+			PromptTokens:     c.tokenizer.Count(string(requestJSON)),
+			CompletionTokens: c.tokenizer.Count(response),
+		}
+	}
+
+	tracker.record(functionName(functions), *usage, estimated, time.Since(started))
+}
+
+// usage returns the token usage in either response format, or nil if none was reported
+func (r *ChatResponse) usage() *Usage {
+	if r.Usage != nil {
+		return r.Usage
+	}
+	if r.PromptEvalCount > 0 || r.EvalCount > 0 {
+		return &Usage{
+			PromptTokens:     r.PromptEvalCount,
+			CompletionTokens: r.EvalCount,
+			TotalTokens:      r.PromptEvalCount + r.EvalCount,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package redact
+
+import (
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const (
+	kindPrivateKey = "private_key"
+	kindEnv        = "env_value"
+	kindEntropy    = "high_entropy"
+
+	// Markers of the added and removed lines of a diff
+	addedPrefix   = "+ "
+	removedPrefix = "- "
+
+	// Withheld replaces the diff of files matching redact.never_send
+	Withheld = "[content withheld: file matches redact.never_send]"
+)
+
+// builtinPatterns detect well-known credential formats. The first capture group, when
+// present, is the part that is redacted.
+var builtinPatterns = []config.RedactPattern{
+	{Name: "aws_access_key", Regex: `\b((?:AKIA|ASIA)[0-9A-Z]{16})\b`},
+	{Name: "aws_secret_key", Regex: `(?i)aws_?secret_?access_?key\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`},
+	{Name: "github_token", Regex: `\b((?:gh[pousr]_[A-Za-z0-9]{36,})|(?:github_pat_[A-Za-z0-9_]{22,}))\b`},
+	{Name: "gitlab_token", Regex: `\b(glpat-[A-Za-z0-9_-]{20,})\b`},
+	{Name: "openai_key", Regex: `\b(sk-(?:proj-|ant-)?[A-Za-z0-9_-]{20,})\b`},
+	{Name: "slack_token", Regex: `\b(xox[abposr]-[A-Za-z0-9-]{10,})\b`},
+	{Name: "google_api_key", Regex: `\b(AIza[0-9A-Za-z_-]{35})\b`},
+	{Name: "stripe_key", Regex: `\b((?:sk|rk|pk)_(?:live|test)_[0-9A-Za-z]{16,})\b`},
+	{Name: "jwt", Regex: `\b(eyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,})\b`},
+	{Name: "url_credentials", Regex: `[a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+:([^/\s@]+)@`},
+	{Name: "bearer_token", Regex: `(?i)\bbearer\s+([A-Za-z0-9._~+/-]{16,}=*)`},
+	{
+		Name: "password",
+		Regex: `(?i)\b(?:password|passwd|pwd|secret|token|api[_-]?key|access[_-]?key|client[_-]?secret|auth)` +
+			`[\w-]*["']?\s*[:=]\s*["']?([^\s"',;()]{4,})(?:["'\s,;]|$)`,
+	},
+}
+
+var (
+	privateKeyBegin = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	privateKeyEnd   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY( BLOCK)?-----`)
+	envAssignment   = regexp.MustCompile(`^(\s*(?:export\s+)?[A-Za-z_][A-Za-z0-9_]*\s*=\s*)(.+)$`)
+	randomToken     = regexp.MustCompile(`[A-Za-z0-9+/=_-]+`)
+)
+
+// Finding describes one redaction, without the redacted value
+type Finding struct {
+	Kind string
+	Line int
+}
+
+type detector struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// Redactor replaces secrets in diffs with placeholders before they are sent to the LLM
+type Redactor struct {
+	enabled   bool
+	detectors []detector
+	allowlist []*regexp.Regexp
+	neverSend []string
+	entropy   float64
+	minLength int
+}
+
+// New compiles the built-in and configured detectors
+func New(cfg config.RedactConfig) (*Redactor, error) {
+	r := &Redactor{
+		enabled:   cfg.Enabled,
+		neverSend: cfg.NeverSend,
+		entropy:   cfg.Entropy,
+		minLength: cfg.MinSecretLength,
+	}
+
+	for _, p := range append(append([]config.RedactPattern{}, builtinPatterns...), cfg.Patterns...) {
+		pattern, err := regexp.Compile(p.Regex)
+		if err != nil {
+			return nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextInvalidRedactPattern, p.Name),
+			)
+		}
+		r.detectors = append(r.detectors, detector{name: p.Name, pattern: pattern})
+	}
+
+	for _, allow := range cfg.Allowlist {
+		pattern, err := regexp.Compile(allow)
+		if err != nil {
+			return nil, errors.WrapWithContext(
+				errors.CodeConfigError,
+				err,
+				errors.FormatContext(errors.ContextInvalidRedactPattern, allow),
+			)
+		}
+		r.allowlist = append(r.allowlist, pattern)
+	}
+
+	return r, nil
+}
+
+// Blocked reports whether the file at path matches redact.never_send. This is enforced even
+// when redaction is disabled.
+func (r *Redactor) Blocked(filePath string) bool {
+	base := path.Base(filePath)
+	for _, pattern := range r.neverSend {
+		if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+			if filePath == dir || strings.HasPrefix(filePath, dir+"/") {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern, filePath); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, base); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the diff of the file at path as it may be sent to the LLM. Files matching
+// redact.never_send are withheld without calling fetch, the diff of others is redacted.
+func (r *Redactor) Diff(filePath string, fetch func(string) (string, error)) (string, error) {
+	if r.Blocked(filePath) {
+		logger.Info().
+			Str("path", filePath).
+			Msg("Withholding file content from the LLM")
+		return Withheld, nil
+	}
+
+	raw, err := fetch(filePath)
+	if err != nil {
+		return "", err
+	}
+	diff, _ := r.Redact(filePath, raw)
+	return diff, nil
+}
+
+// Redact replaces secrets in the diff of the file at path with [REDACTED:<kind>] placeholders
+// and logs what was redacted, never the values
+func (r *Redactor) Redact(filePath, diff string) (string, []Finding) {
+	if !r.enabled {
+		return diff, nil
+	}
+
+	isEnv := isEnvFile(filePath)
+	lines := strings.Split(diff, "\n")
+	var findings []Finding
+
+	// Private keys span lines, everything between the markers is dropped. Removed and added
+	// lines interleave, so the old and the new side of the diff each track their own key.
+	oldKey, newKey := false, false
+
+	for i, line := range lines {
+		prefix, body := splitDiffLine(line)
+
+		// Removed lines belong to the old side, added lines to the new one, others to both
+		onOld, onNew := prefix != addedPrefix, prefix != removedPrefix
+		inPrivateKey := onOld && oldKey || onNew && newKey
+		if begins := privateKeyBegin.MatchString(body); begins || inPrivateKey {
+			if !inPrivateKey {
+				findings = append(findings, Finding{Kind: kindPrivateKey, Line: i + 1})
+			}
+			ends := privateKeyEnd.MatchString(body)
+			if onOld && (oldKey || begins) {
+				oldKey = !ends
+			}
+			if onNew && (newKey || begins) {
+				newKey = !ends
+			}
+			lines[i] = prefix + placeholder(kindPrivateKey)
+			continue
+		}
+
+		if isEnv {
+			if m := envAssignment.FindStringSubmatch(body); m != nil && !r.allowed(m[2]) && !isPlaceholder(m[2]) {
+				findings = append(findings, Finding{Kind: kindEnv, Line: i + 1})
+				lines[i] = prefix + m[1] + placeholder(kindEnv)
+				continue
+			}
+		}
+
+		for _, d := range r.detectors {
+			var count int
+			body, count = r.replace(body, d.pattern, d.name)
+			for range count {
+				findings = append(findings, Finding{Kind: d.name, Line: i + 1})
+			}
+		}
+
+		if r.entropy > 0 {
+			var count int
+			body, count = r.replaceRandom(body)
+			for range count {
+				findings = append(findings, Finding{Kind: kindEntropy, Line: i + 1})
+			}
+		}
+
+		lines[i] = prefix + body
+	}
+
+	if len(findings) > 0 {
+		logger.Warn().
+			Str("path", filePath).
+			Int("count", len(findings)).
+			Str("kinds", strings.Join(kinds(findings), ", ")).
+			Msg("Redacted secrets from diff before sending it to the LLM")
+	}
+
+	return strings.Join(lines, "\n"), findings
+}
+
+// replace redacts every match of pattern in text, or its first capture group if it has one
+func (r *Redactor) replace(text string, pattern *regexp.Regexp, kind string) (string, int) {
+	matches := pattern.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return text, 0
+	}
+
+	var out strings.Builder
+	last, count := 0, 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		secret := text[start:end]
+		if r.allowed(secret) || isPlaceholder(secret) {
+			continue
+		}
+		out.WriteString(text[last:start])
+		out.WriteString(placeholder(kind))
+		last = end
+		count++
+	}
+	out.WriteString(text[last:])
+
+	return out.String(), count
+}
+
+// replaceRandom redacts long tokens that mix letters and digits with high Shannon entropy
+func (r *Redactor) replaceRandom(text string) (string, int) {
+	count := 0
+	redacted := randomToken.ReplaceAllStringFunc(text, func(token string) string {
+		if len(token) < r.minLength || r.allowed(token) || !mixesLettersAndDigits(token) {
+			return token
+		}
+		if shannonEntropy(token) < r.entropy {
+			return token
+		}
+		count++
+		return placeholder(kindEntropy)
+	})
+	return redacted, count
+}
+
+func (r *Redactor) allowed(value string) bool {
+	for _, pattern := range r.allowlist {
+		if pattern.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitDiffLine separates the "+ " or "- " marker of a diff line from its content
+func splitDiffLine(line string) (string, string) {
+	if strings.HasPrefix(line, addedPrefix) || strings.HasPrefix(line, removedPrefix) {
+		return line[:len(addedPrefix)], line[len(addedPrefix):]
+	}
+	return "", line
+}
+
+func isEnvFile(filePath string) bool {
+	base := path.Base(filePath)
+	return base == ".env" || strings.HasPrefix(base, ".env.") || strings.HasSuffix(base, ".env")
+}
+
+func placeholder(kind string) string {
+	return "[REDACTED:" + kind + "]"
+}
+
+func isPlaceholder(value string) bool {
+	return strings.Contains(value, "[REDACTED:")
+}
+
+func mixesLettersAndDigits(token string) bool {
+	var letter, digit bool
+	for _, c := range token {
+		switch {
+		case c >= '0' && c <= '9':
+			digit = true
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+			letter = true
+		}
+	}
+	return letter && digit
+}
+
+// shannonEntropy returns the entropy of s in bits per character
+func shannonEntropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, c := range s {
+		counts[c]++
+	}
+	entropy := 0.0
+	length := float64(len(s))
+	for _, n := range counts {
+		p := float64(n) / length
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// kinds returns the distinct kinds of findings, sorted
+func kinds(findings []Finding) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, f := range findings {
+		if !seen[f.Kind] {
+			seen[f.Kind] = true
+			out = append(out, f.Kind)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,190 @@
+// Package secret resolves references to secrets kept outside the configuration
+package secret
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+// Reference schemes, written as <scheme>:<target>
+const (
+	SchemeEnv     = "env"     // env:OPENAI_API_KEY
+	SchemeFile    = "file"    // file:~/.config/bumpa/key
+	SchemeCommand = "cmd"     // cmd:pass show openai
+	SchemeKeyring = "keyring" // keyring:bumpa/openai, a service and account
+)
+
+// commandTimeout bounds secret commands, which may wait for a passphrase
+var commandTimeout = time.Minute
+
+const (
+	// commandWaitDelay bounds the wait for processes a timed out command left behind, which
+	// keep its output open
+	commandWaitDelay = time.Second
+
+	// keyringTool looks secrets up through the Secret Service D-Bus API
+	keyringTool = "secret-tool"
+
+	// privatePerms are the permission bits a key file should not have
+	privatePerms = 0o077
+)
+
+// Schemes lists the reference schemes
+var Schemes = []string{SchemeEnv, SchemeFile, SchemeCommand, SchemeKeyring}
+
+// Split returns the scheme and target of a reference, or an empty scheme if value is not one
+func Split(value string) (string, string) {
+	scheme, target, found := strings.Cut(value, ":")
+	if !found {
+		return "", value
+	}
+	for _, known := range Schemes {
+		if scheme == known {
+			return scheme, target
+		}
+	}
+	return "", value
+}
+
+// IsReference reports whether value refers to a secret rather than being one
+func IsReference(value string) bool {
+	scheme, _ := Split(value)
+	return scheme != ""
+}
+
+// Resolve returns the secret value refers to, or value itself if it is not a reference. Errors
+// name the reference, never the secret.
+func Resolve(value string) (string, error) {
+	scheme, target := Split(value)
+	if scheme == "" {
+		return value, nil
+	}
+
+	var secret string
+	var err error
+	switch scheme {
+	case SchemeEnv:
+		secret, err = fromEnv(target)
+	case SchemeFile:
+		secret, err = fromFile(target)
+	case SchemeCommand:
+		secret, err = fromCommand(target)
+	case SchemeKeyring:
+		secret, err = fromKeyring(target)
+	}
+	if err != nil {
+		return "", errors.WrapWithContext(
+			errors.CodeConfigError,
+			err,
+			errors.FormatContext(errors.ContextSecretResolve, describe(scheme, target)),
+		)
+	}
+	if secret == "" {
+		return "", errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidConfig,
+			errors.FormatContext(errors.ContextSecretEmpty, describe(scheme, target)),
+		)
+	}
+
+	logger.Debug().Str("reference", describe(scheme, target)).Msg("Secret resolved")
+	return secret, nil
+}
+
+// describe names a reference for messages. Commands are left out, they may hold arguments
+// that are better not repeated.
+func describe(scheme, target string) string {
+	if scheme == SchemeCommand {
+		return scheme + " reference"
+	}
+	return scheme + ":" + target
+}
+
+func fromEnv(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.ErrNotFound
+	}
+	return value, nil
+}
+
+func fromFile(path string) (string, error) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, rest)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&privatePerms != 0 {
+		logger.Warn().
+			Str("file", path).
+			Msg("Key file is readable by other users, restrict it with chmod 600")
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// fromCommand runs command with the shell and returns its output. The command shares the
+// terminal, so tools like pass can ask for a passphrase.
+func fromCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.WaitDelay = commandWaitDelay
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// fromKeyring looks up the secret stored for service/account in the Secret Service, under the
+// service and username attributes other keyring libraries use as well
+func fromKeyring(target string) (string, error) {
+	service, account, found := strings.Cut(target, "/")
+	if !found || service == "" || account == "" {
+		return "", errors.ErrInvalidInput
+	}
+
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return "", errors.ErrNoKeyring
+	}
+	tool, err := exec.LookPath(keyringTool)
+	if err != nil {
+		return "", errors.ErrNoKeyring
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(ctx, tool, "lookup", "service", service, "username", account)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		// secret-tool exits non-zero without output when nothing matches
+		return "", errors.ErrNotFound
+	}
+	return strings.TrimSuffix(stdout.String(), "\n"), nil
+}
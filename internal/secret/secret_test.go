@@ -0,0 +1,174 @@
+package secret
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/logger"
+)
+
+const testSecret = "sk-test-0123456789abcdef"
+
+// checkError fails unless err names reference and leaves out the secret
+func checkError(t *testing.T, err error, reference string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("%s: got no error", reference)
+	}
+	if !strings.Contains(err.Error(), reference) {
+		t.Errorf("got %q, want it to name %s", err, reference)
+	}
+	if strings.Contains(err.Error(), testSecret) {
+		t.Errorf("got %q, which contains the secret", err)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		value, scheme, target string
+	}{
+		{"env:OPENAI_API_KEY", SchemeEnv, "OPENAI_API_KEY"},
+		{"file:~/.config/bumpa/key", SchemeFile, "~/.config/bumpa/key"},
+		{"cmd:pass show openai", SchemeCommand, "pass show openai"},
+		{"keyring:bumpa/openai", SchemeKeyring, "bumpa/openai"},
+		{"sk-plain:key", "", "sk-plain:key"},
+		{testSecret, "", testSecret},
+	}
+
+	for _, tt := range tests {
+		if scheme, target := Split(tt.value); scheme != tt.scheme || target != tt.target {
+			t.Errorf("%s: got %q and %q, want %q and %q", tt.value, scheme, target, tt.scheme, tt.target)
+		}
+	}
+}
+
+func TestResolvePlain(t *testing.T) {
+	if got, err := Resolve(testSecret); err != nil || got != testSecret {
+		t.Errorf("got %q and %v, want the value itself", got, err)
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	t.Setenv("BUMPA_TEST_KEY", testSecret)
+	t.Setenv("BUMPA_TEST_EMPTY", "")
+
+	if got, err := Resolve("env:BUMPA_TEST_KEY"); err != nil || got != testSecret {
+		t.Errorf("got %q and %v, want the variable", got, err)
+	}
+
+	_, err := Resolve("env:BUMPA_TEST_MISSING")
+	checkError(t, err, "env:BUMPA_TEST_MISSING")
+	if !errors.Is(err, errors.ErrNotFound) {
+		t.Errorf("got %v, want ErrNotFound", err)
+	}
+
+	_, err = Resolve("env:BUMPA_TEST_EMPTY")
+	checkError(t, err, "env:BUMPA_TEST_EMPTY")
+}
+
+func TestResolveFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	if err := os.WriteFile(filepath.Join(home, "key"), []byte(testSecret+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Resolve("file:~/key"); err != nil || got != testSecret {
+		t.Errorf("got %q and %v, want the trimmed file content", got, err)
+	}
+	if got, err := Resolve("file:" + filepath.Join(home, "key")); err != nil || got != testSecret {
+		t.Errorf("got %q and %v for an absolute path", got, err)
+	}
+
+	_, err := Resolve("file:~/missing")
+	checkError(t, err, "file:~/missing")
+}
+
+func TestResolveFileWarnsAboutPermissions(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "bumpa.log")
+	if err := logger.Init(logger.Config{Output: "file", Path: logFile, Level: "warn", FilePerms: 0o600}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, perms := range []os.FileMode{0o600, 0o644} {
+		path := filepath.Join(dir, "key-"+perms.String())
+		if err := os.WriteFile(path, []byte(testSecret), perms); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, perms); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Resolve("file:" + path); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(log), "readable by other users"); got != 1 {
+		t.Errorf("got %d warnings in %q, want one for the 644 file", got, log)
+	}
+	if strings.Contains(string(log), testSecret) {
+		t.Errorf("got %q, which contains the secret", log)
+	}
+}
+
+func TestResolveCommand(t *testing.T) {
+	if got, err := Resolve("cmd:printf '%s\\n' " + testSecret); err != nil || got != testSecret {
+		t.Errorf("got %q and %v, want the trimmed output", got, err)
+	}
+
+	// Commands are not repeated, they may hold arguments that are better kept private
+	_, err := Resolve("cmd:echo " + testSecret + "; exit 3")
+	checkError(t, err, "cmd reference")
+
+	_, err = Resolve("cmd:true")
+	checkError(t, err, "cmd reference")
+}
+
+func TestResolveCommandTimeout(t *testing.T) {
+	timeout := commandTimeout
+	commandTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { commandTimeout = timeout })
+
+	start := time.Now()
+	_, err := Resolve("cmd:sleep 3; echo late")
+	checkError(t, err, "cmd reference")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("took %s, want the command stopped after its timeout", elapsed)
+	}
+}
+
+func TestResolveKeyring(t *testing.T) {
+	_, err := Resolve("keyring:bumpa")
+	checkError(t, err, "keyring:bumpa")
+	if !errors.Is(err, errors.ErrInvalidInput) {
+		t.Errorf("got %v, want ErrInvalidInput for a reference without an account", err)
+	}
+
+	t.Run("no session bus", func(t *testing.T) {
+		t.Setenv("DBUS_SESSION_BUS_ADDRESS", "")
+		_, err := Resolve("keyring:bumpa/openai")
+		checkError(t, err, "keyring:bumpa/openai")
+		if !errors.Is(err, errors.ErrNoKeyring) {
+			t.Errorf("got %v, want ErrNoKeyring", err)
+		}
+	})
+
+	t.Run("missing entry", func(t *testing.T) {
+		if _, err := exec.LookPath(keyringTool); err != nil || os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+			t.Skip("secret-tool and a session bus are required")
+		}
+		_, err := Resolve("keyring:bumpa-test/no-such-account")
+		checkError(t, err, "keyring:bumpa-test/no-such-account")
+	})
+}
@@ -0,0 +1,176 @@
+package setup
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+)
+
+const (
+	// maxSearchDepth limits how deep Go sources are searched for a version constant
+	maxSearchDepth = 3
+
+	// versionPattern matches a semantic version, with optional pre-release and build metadata
+	versionPattern = `\d+\.\d+\.\d+(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?`
+)
+
+// Project is a kind of project found in the repository, with the files holding its version
+type Project struct {
+	Kind    string
+	Version string               // Version found in the first file, empty if none
+	Files   []config.VersionFile // Replace patterns built from the text found in each file
+	Ignore  []string             // Lockfiles and generated code not worth describing to the LLM
+}
+
+// detector recognizes one kind of project by its manifest
+type detector struct {
+	kind     string
+	manifest string
+	files    func(root string) []string // Files holding the version, the manifest if nil
+	pattern  *regexp.Regexp             // The first capture group is the version
+	ignore   []string
+}
+
+var detectors = []detector{
+	{
+		kind:     "Go module",
+		manifest: "go.mod",
+		files:    goVersionFiles,
+		pattern:  regexp.MustCompile(`Version\s*=\s*"(` + versionPattern + `)"`),
+		ignore:   []string{"go.sum", "*.pb.go", "*_gen.go"},
+	},
+	{
+		kind:     "npm package",
+		manifest: "package.json",
+		pattern:  regexp.MustCompile(`"version"\s*:\s*"(` + versionPattern + `)"`),
+		ignore:   []string{"package-lock.json", "yarn.lock", "pnpm-lock.yaml", "*.min.js", "*.map"},
+	},
+	{
+		kind:     "Python project",
+		manifest: "pyproject.toml",
+		pattern:  regexp.MustCompile(`(?m)^version\s*=\s*"(` + versionPattern + `)"`),
+		ignore:   []string{"poetry.lock", "uv.lock", "Pipfile.lock"},
+	},
+	{
+		kind:     "Rust crate",
+		manifest: "Cargo.toml",
+		pattern:  regexp.MustCompile(`(?m)^version\s*=\s*"(` + versionPattern + `)"`),
+		ignore:   []string{"Cargo.lock"},
+	},
+	{
+		kind:     "Helm chart",
+		manifest: "Chart.yaml",
+		files:    chartFiles,
+		pattern:  regexp.MustCompile(`(?m)^(?:app)?[vV]ersion:\s*"?(` + versionPattern + `)"?`),
+	},
+	{
+		kind:     "VERSION file",
+		manifest: "VERSION",
+		pattern:  regexp.MustCompile(`^\s*(` + versionPattern + `)`),
+	},
+}
+
+// Detect returns the projects found in the repository at root, in a fixed order. Projects
+// without a file holding a version are returned too, for their ignore patterns.
+func Detect(root string) []Project {
+	var projects []Project
+	for _, d := range detectors {
+		var paths []string
+		if d.files != nil {
+			paths = d.files(root)
+		} else if exists(root, d.manifest) {
+			paths = []string{d.manifest}
+		}
+		if len(paths) == 0 && !exists(root, d.manifest) {
+			continue
+		}
+
+		project := Project{Kind: d.kind, Ignore: d.ignore}
+		for _, path := range paths {
+			content, err := os.ReadFile(filepath.Join(root, path))
+			if err != nil {
+				continue
+			}
+			replace, found := replacePatterns(d.pattern, string(content))
+			if len(replace) == 0 {
+				continue
+			}
+			if project.Version == "" {
+				project.Version = found
+			}
+			project.Files = append(project.Files, config.VersionFile{Path: path, Replace: replace})
+		}
+		projects = append(projects, project)
+	}
+
+	return projects
+}
+
+// replacePatterns returns a replace pattern for every match of pattern in content, made of the
+// matched text with the version replaced by {version}, and the first version found
+func replacePatterns(pattern *regexp.Regexp, content string) ([]string, string) {
+	var replace []string
+	var found string
+	for _, match := range pattern.FindAllStringSubmatchIndex(content, -1) {
+		text := strings.TrimSpace(content[match[0]:match[2]] + "{version}" + content[match[3]:match[1]])
+		if found == "" {
+			found = content[match[2]:match[3]]
+		}
+		if !slices.Contains(replace, text) {
+			replace = append(replace, text)
+		}
+	}
+	return replace, found
+}
+
+// goVersionFiles returns the version.go files of a Go module, skipping vendored code
+func goVersionFiles(root string) []string {
+	if !exists(root, "go.mod") {
+		return nil
+	}
+
+	var paths []string
+	_ = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil //nolint:nilerr // Unreadable directories are skipped
+		}
+		rel, _ := filepath.Rel(root, path)
+		if entry.IsDir() {
+			if rel != "." && (strings.HasPrefix(entry.Name(), ".") || entry.Name() == "vendor" ||
+				entry.Name() == "node_modules" || strings.Count(rel, string(filepath.Separator)) >= maxSearchDepth) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entry.Name() == "version.go" {
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	return paths
+}
+
+// chartFiles returns the Chart.yaml of a chart in the root and of charts in charts/
+func chartFiles(root string) []string {
+	var paths []string
+	if exists(root, "Chart.yaml") {
+		paths = append(paths, "Chart.yaml")
+	}
+	matches, _ := filepath.Glob(filepath.Join(root, "charts", "*", "Chart.yaml"))
+	for _, match := range matches {
+		rel, _ := filepath.Rel(root, match)
+		paths = append(paths, filepath.ToSlash(rel))
+	}
+	return paths
+}
+
+func exists(root, name string) bool {
+	_, err := os.Stat(filepath.Join(root, name))
+	return err == nil
+}
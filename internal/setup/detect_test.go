@@ -0,0 +1,91 @@
+package setup
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+)
+
+func TestDetect(t *testing.T) {
+	goIgnore := []string{"go.sum", "*.pb.go", "*_gen.go"}
+
+	tests := []struct {
+		dir  string
+		want []Project
+	}{
+		{
+			// Vendored code, hidden directories and directories deeper than maxSearchDepth are skipped
+			dir: "gomod",
+			want: []Project{{
+				Kind:    "Go module",
+				Version: "1.4.2",
+				Files:   []config.VersionFile{{Path: "internal/version/version.go", Replace: []string{`Version = "{version}"`}}},
+				Ignore:  goIgnore,
+			}},
+		},
+		{
+			// Modules without a version constant still contribute their ignore patterns
+			dir:  "gonoversion",
+			want: []Project{{Kind: "Go module", Ignore: goIgnore}},
+		},
+		{
+			dir: "npm",
+			want: []Project{{
+				Kind:    "npm package",
+				Version: "2.0.0-beta.1",
+				Files:   []config.VersionFile{{Path: "package.json", Replace: []string{`"version": "{version}"`}}},
+				Ignore:  []string{"package-lock.json", "yarn.lock", "pnpm-lock.yaml", "*.min.js", "*.map"},
+			}},
+		},
+		{
+			dir: "python",
+			want: []Project{{
+				Kind:    "Python project",
+				Version: "0.3.1",
+				Files:   []config.VersionFile{{Path: "pyproject.toml", Replace: []string{`version = "{version}"`}}},
+				Ignore:  []string{"poetry.lock", "uv.lock", "Pipfile.lock"},
+			}},
+		},
+		{
+			dir: "rust",
+			want: []Project{{
+				Kind:    "Rust crate",
+				Version: "1.0.0+build.7",
+				Files:   []config.VersionFile{{Path: "Cargo.toml", Replace: []string{`version = "{version}"`}}},
+				Ignore:  []string{"Cargo.lock"},
+			}},
+		},
+		{
+			dir: "helm",
+			want: []Project{{
+				Kind:    "Helm chart",
+				Version: "0.5.0",
+				Files: []config.VersionFile{
+					{Path: "Chart.yaml", Replace: []string{"version: {version}", `appVersion: "{version}"`}},
+					{Path: "charts/worker/Chart.yaml", Replace: []string{"version: {version}"}},
+				},
+			}},
+		},
+		{
+			dir: "version",
+			want: []Project{{
+				Kind:    "VERSION file",
+				Version: "3.1.4",
+				Files:   []config.VersionFile{{Path: "VERSION", Replace: []string{"{version}"}}},
+			}},
+		},
+		{
+			dir:  "empty",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		got := Detect(filepath.Join("testdata", tt.dir))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.dir, got, tt.want)
+		}
+	}
+}
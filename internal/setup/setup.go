@@ -0,0 +1,161 @@
+// Package setup creates the initial configuration of a repository for bumpa init
+package setup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/errors"
+)
+
+const (
+	filePerms = 0o600
+	hookPerms = 0o755 // Hooks must be executable
+
+	// HookName is the git hook bumpa installs
+	HookName = "prepare-commit-msg"
+
+	// hookMarker identifies hooks installed by bumpa, which may be replaced
+	hookMarker = "# Installed by bumpa init"
+)
+
+// hookScript is the prepare-commit-msg hook documented in the README
+const hookScript = `#!/bin/sh
+` + hookMarker + `
+bumpa commit
+`
+
+// Options are the answers of the wizard
+type Options struct {
+	Provider string
+	BaseURL  string
+	Model    string
+	APIKey   string // A reference to the key, keys themselves are never written
+	Ignore   []string
+	Files    []config.VersionFile
+	Commit   bool
+	Tag      bool
+}
+
+// ConfigVersion is the config_version written, the current one
+func (o *Options) ConfigVersion() int {
+	return config.CurrentConfigVersion
+}
+
+var configTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"quote": quote}).Parse(
+	`# Generated by bumpa init, see bumpa.example.yaml for all settings.
+# Keep the API key out of this file, in BUMPA_LLM_API_KEY or a reference such as
+# api_key: env:OPENAI_API_KEY.
+config_version: {{.ConfigVersion}}
+
+llm:
+  provider: {{quote .Provider}}
+  base_url: {{quote .BaseURL}}
+  model: {{quote .Model}}{{with .APIKey}}
+  api_key: {{quote .}}{{end}}
+
+git:
+  ignore:{{range .Ignore}}
+    - {{quote .}}{{else}} []{{end}}
+
+version:
+  git:
+    commit: {{.Commit}}
+    tag: {{.Tag}}
+  files:{{range .Files}}
+    - path: {{quote .Path}}{{if .Replace}}
+      replace:{{range .Replace}}
+        - {{quote .}}{{end}}{{end}}{{else}} []{{end}}
+`))
+
+// Render writes the configuration file of opts
+func Render(w io.Writer, opts *Options) error {
+	if err := configTemplate.Execute(w, opts); err != nil {
+		return errors.Wrap(errors.CodeIOError, err)
+	}
+	return nil
+}
+
+// WriteConfig writes the configuration file of opts to path
+func WriteConfig(path string, opts *Options) error {
+	var buf bytes.Buffer
+	if err := Render(&buf, opts); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), filePerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, path),
+		)
+	}
+	return nil
+}
+
+// Ignore returns the ignore patterns of projects, without duplicates
+func Ignore(projects []Project) []string {
+	patterns := []string{}
+	seen := make(map[string]bool)
+	for _, project := range projects {
+		for _, pattern := range project.Ignore {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// Files returns the version files of projects
+func Files(projects []Project) []config.VersionFile {
+	var files []config.VersionFile
+	for _, project := range projects {
+		files = append(files, project.Files...)
+	}
+	return files
+}
+
+// HookPath returns the path of the hook in the repository with git directory gitDir
+func HookPath(gitDir string) string {
+	return filepath.Join(gitDir, "hooks", HookName)
+}
+
+// InstallHook writes the prepare-commit-msg hook, refusing to replace one bumpa did not install
+func InstallHook(gitDir string) error {
+	path := HookPath(gitDir)
+	if existing, err := os.ReadFile(path); err == nil && !bytes.Contains(existing, []byte(hookMarker)) {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			errors.ErrInvalidInput,
+			errors.FormatContext(errors.ContextHookExists, path),
+		)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), hookPerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextDirCreate, filepath.Dir(path)),
+		)
+	}
+	//nolint:gosec // Hooks must be executable
+	if err := os.WriteFile(path, []byte(hookScript), hookPerms); err != nil {
+		return errors.WrapWithContext(
+			errors.CodeIOError,
+			err,
+			errors.FormatContext(errors.ContextFileWrite, path),
+		)
+	}
+	return nil
+}
+
+// quote single-quotes s for YAML
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,3 @@
+package tools
+
+const Version = "9.9.9"
@@ -0,0 +1,3 @@
+package c
+
+const Version = "9.9.9"
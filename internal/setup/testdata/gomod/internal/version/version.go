@@ -0,0 +1,4 @@
+package version
+
+// Version is the current release
+const Version = "1.4.2"
@@ -2,6 +2,7 @@ package version
 
 import (
 	"regexp"
+	"slices"
 	"strings"
 
 	"codeberg.org/mutker/bumpa/internal/errors"
@@ -17,34 +18,55 @@ const (
 	bumpTypeNone  = ""
 )
 
+// conventionalHeader matches a Conventional Commits header, capturing its type and breaking marker
+var conventionalHeader = regexp.MustCompile(`^([A-Za-z]+)(?:\([^)]*\))?(!)?: `)
+
 // Parser handles semantic version parsing and validation
 type Parser struct {
 	currentVersion   *semver.Version
+	channels         []string       // Pre-release channels, least stable first
+	preRelease       *regexp.Regexp // Matches a pre-release on one of channels
 	breakingKeywords []string
 	featureKeywords  []string
 }
 
-// New creates a Parser instance with the current version and keyword lists for change analysis
-func New(current *semver.Version, breakingKeywords, featureKeywords []string) *Parser {
+// New creates a Parser instance with the current version, the pre-release channels in order
+// and keyword lists for change analysis
+func New(current *semver.Version, channels, breakingKeywords, featureKeywords []string) *Parser {
 	return &Parser{
 		currentVersion:   current,
+		channels:         channels,
+		preRelease:       preReleasePattern(channels),
 		breakingKeywords: breakingKeywords,
 		featureKeywords:  featureKeywords,
 	}
 }
 
 // ParseSuggestion parses a version suggestion string into bump type and prerelease components.
-// Accepts both full versions (e.g., "1.2.3-beta1") and simple formats (e.g., "minor:beta1" or "beta2")
+// Accepts both full versions (e.g., "1.2.3-beta1") and simple formats (e.g., "minor:beta1" or "beta2").
+// Suggestions leading to a version before the current one, like rc1 to alpha1, are rejected.
 func (p *Parser) ParseSuggestion(suggestion string) (string, string, error) {
 	suggestion = strings.TrimSpace(suggestion)
 
-	// Handle full version format (e.g., "0.1.0-beta1")
+	parse := p.parseSimpleVersion // Simple format (e.g., "minor:beta1" or "beta2")
 	if strings.Contains(suggestion, ".") {
-		return p.parseFullVersion(suggestion)
+		parse = p.parseFullVersion // Full version format (e.g., "0.1.0-beta1")
+	}
+
+	bumpType, preRelease, err := parse(suggestion)
+	if err != nil {
+		return "", "", err
 	}
 
-	// Handle simple format (e.g., "minor:beta1" or "beta2")
-	return p.parseSimpleVersion(suggestion)
+	proposed, err := ProposeVersion(p.currentVersion, bumpType, preRelease)
+	if err != nil {
+		return "", "", err
+	}
+	if err := p.CheckProgression(proposed); err != nil {
+		return "", "", err
+	}
+
+	return bumpType, preRelease, nil
 }
 
 // parseFullVersion parses a complete version string (e.g., "1.2.3-beta1")
@@ -59,7 +81,7 @@ func (p *Parser) parseFullVersion(version string) (string, string, error) {
 	}
 
 	preRelease := ver.Prerelease()
-	if preRelease != "" && !isValidPrerelease(preRelease) {
+	if preRelease != "" && !p.isValidPrerelease(preRelease) {
 		return "", "", errors.WrapWithContext(
 			errors.CodeValidateError,
 			errors.ErrInvalidInput,
@@ -67,12 +89,17 @@ func (p *Parser) parseFullVersion(version string) (string, string, error) {
 		)
 	}
 
+	// The bump type alone would hide a suggestion below the current version
+	if err := p.CheckProgression(ver); err != nil {
+		return "", "", err
+	}
+
 	bumpType := p.determineBumpType(ver)
 	return bumpType, preRelease, nil
 }
 
 // parseSimpleVersion parses a simplified version format (e.g., "minor:beta1" or "beta2")
-func (*Parser) parseSimpleVersion(suggestion string) (string, string, error) {
+func (p *Parser) parseSimpleVersion(suggestion string) (string, string, error) {
 	parts := strings.Split(suggestion, ":")
 
 	switch len(parts) {
@@ -82,7 +109,7 @@ func (*Parser) parseSimpleVersion(suggestion string) (string, string, error) {
 		if err := validateBumpType(bumpType); err != nil {
 			return "", "", err
 		}
-		if preRelease != "" && !isValidPrerelease(preRelease) {
+		if preRelease != "" && !p.isValidPrerelease(preRelease) {
 			return "", "", errors.WrapWithContext(
 				errors.CodeValidateError,
 				errors.ErrInvalidInput,
@@ -95,7 +122,7 @@ func (*Parser) parseSimpleVersion(suggestion string) (string, string, error) {
 		if suggestion == "stable" {
 			return bumpTypeNone, "", nil
 		}
-		if !isValidPrerelease(suggestion) {
+		if !p.isValidPrerelease(suggestion) {
 			return "", "", errors.WrapWithContext(
 				errors.CodeValidateError,
 				errors.ErrInvalidInput,
@@ -112,6 +139,42 @@ func (*Parser) parseSimpleVersion(suggestion string) (string, string, error) {
 	}
 }
 
+// BumpFromCommits determines the bump type from Conventional Commits messages: breaking changes
+// bump major, features minor and fixes or performance improvements patch. Other commits,
+// including ones not following the convention, don't affect the version.
+func (p *Parser) BumpFromCommits(messages []string) string {
+	bumpType := bumpTypeNone
+	for _, message := range messages {
+		header, _, _ := strings.Cut(message, "\n")
+		match := conventionalHeader.FindStringSubmatch(header)
+		if match == nil {
+			continue
+		}
+
+		if match[2] == "!" || p.hasKeyword(message, p.breakingKeywords) {
+			return bumpTypeMajor
+		}
+
+		switch commitType := strings.ToLower(match[1]); {
+		case slices.Contains(p.featureKeywords, commitType+":"):
+			bumpType = bumpTypeMinor
+		case (commitType == "fix" || commitType == "perf") && bumpType == bumpTypeNone:
+			bumpType = bumpTypePatch
+		}
+	}
+	return bumpType
+}
+
+// hasKeyword reports whether text contains any of keywords
+func (*Parser) hasKeyword(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
 // validateBumpType ensures the bump type is valid
 func validateBumpType(bumpType string) error {
 	switch bumpType {
@@ -126,9 +189,9 @@ func validateBumpType(bumpType string) error {
 	}
 }
 
-// isValidPrerelease checks if the prerelease suffix matches the pattern: alpha|beta|rc + number
-func isValidPrerelease(preRelease string) bool {
-	return regexp.MustCompile(`^(alpha|beta|rc)\d+$`).MatchString(preRelease)
+// isValidPrerelease checks if the prerelease suffix is one of the channels with an optional number
+func (p *Parser) isValidPrerelease(preRelease string) bool {
+	return p.preRelease.MatchString(preRelease)
 }
 
 // determineBumpType compares a proposed version against the current version
@@ -155,18 +218,13 @@ func ProposeVersion(current *semver.Version, bumpType, preRelease string) (*semv
 		)
 	}
 
-	var newVersion semver.Version
-	switch bumpType {
-	case bumpTypeMajor:
-		newVersion = current.IncMajor()
-	case bumpTypeMinor:
-		newVersion = current.IncMinor()
-	case bumpTypePatch:
-		newVersion = current.IncPatch()
-	default:
-		newVersion = *current
+	// A pre-release of the current, already released version would precede it
+	if preRelease != "" && current.Prerelease() == "" && bumpType == bumpTypeNone {
+		bumpType = bumpTypePatch
 	}
 
+	newVersion := nextRelease(current, bumpType)
+
 	if preRelease != "" {
 		ver, err := newVersion.SetPrerelease(preRelease)
 		if err != nil {
@@ -0,0 +1,218 @@
+package version
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"codeberg.org/mutker/bumpa/internal/errors"
+	"codeberg.org/mutker/bumpa/internal/git"
+	"codeberg.org/mutker/bumpa/internal/logger"
+	"github.com/Masterminds/semver/v3"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// bumpRanks orders bump types by the component they increment
+var bumpRanks = map[string]int{
+	bumpTypeNone:  0,
+	bumpTypePatch: 1,
+	bumpTypeMinor: 2,
+	bumpTypeMajor: 3,
+}
+
+// preReleasePattern returns the pattern of a pre-release of one of channels, capturing the
+// channel and its optional number
+func preReleasePattern(channels []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		quoted = append(quoted, regexp.QuoteMeta(channel))
+	}
+	return regexp.MustCompile(`^(` + strings.Join(quoted, "|") + `)(\d*)$`)
+}
+
+// splitPreRelease returns the channel and number of a pre-release such as beta2, with number 0
+// if it has none. The channel is empty if the pre-release is not on a known channel.
+func (p *Parser) splitPreRelease(preRelease string) (string, int) {
+	match := p.preRelease.FindStringSubmatch(preRelease)
+	if match == nil {
+		return "", 0
+	}
+	number, _ := strconv.Atoi(match[2])
+	return match[1], number
+}
+
+// CheckProgression rejects proposed versions that precede the current one, either by release
+// or, for pre-releases of the same release, by channel order
+func (p *Parser) CheckProgression(proposed *semver.Version) error {
+	current := p.currentVersion
+	currentRelease, proposedRelease := releaseOf(current), releaseOf(proposed)
+
+	switch {
+	case proposedRelease.LessThan(&currentRelease):
+		return regression(proposed, current)
+	case proposedRelease.GreaterThan(&currentRelease), proposed.Prerelease() == "":
+		return nil
+	case current.Prerelease() == "":
+		// A pre-release of a version that is already released
+		return regression(proposed, current)
+	}
+
+	currentChannel, _ := p.splitPreRelease(current.Prerelease())
+	proposedChannel, _ := p.splitPreRelease(proposed.Prerelease())
+	if slices.Index(p.channels, proposedChannel) < slices.Index(p.channels, currentChannel) {
+		return regression(proposed, current)
+	}
+
+	return nil
+}
+
+func regression(proposed, current *semver.Version) error {
+	return errors.WrapWithContext(
+		errors.CodeValidateError,
+		errors.ErrInvalidInput,
+		errors.FormatContext(errors.ContextVersionRegression, proposed.String(), current.String()),
+	)
+}
+
+// releaseOf returns v without pre-release and metadata
+func releaseOf(v *semver.Version) semver.Version {
+	return *semver.New(v.Major(), v.Minor(), v.Patch(), "", "")
+}
+
+// releaseLevel returns the bump type a release was created by, judging by its trailing zeros
+func releaseLevel(v *semver.Version) string {
+	switch {
+	case v.Patch() > 0:
+		return bumpTypePatch
+	case v.Minor() > 0:
+		return bumpTypeMinor
+	default:
+		return bumpTypeMajor
+	}
+}
+
+// nextRelease returns the release bumpType leads to. A pre-release continues towards its own
+// release unless the bump goes beyond it, so a feature on top of 1.3.0-beta1 stays 1.3.0 while
+// a breaking change moves on to 2.0.0.
+func nextRelease(current *semver.Version, bumpType string) semver.Version {
+	if current.Prerelease() != "" && bumpRanks[bumpType] <= bumpRanks[releaseLevel(current)] {
+		return releaseOf(current)
+	}
+
+	switch bumpType {
+	case bumpTypeMajor:
+		return current.IncMajor()
+	case bumpTypeMinor:
+		return current.IncMinor()
+	case bumpTypePatch:
+		return current.IncPatch()
+	default:
+		return *current
+	}
+}
+
+// propose sets the proposed version from a bump type and a suggested pre-release. The channel
+// requested on the command line replaces the suggested one, and the number is always the one
+// after the highest existing pre-release of the channel.
+func (b *Bumper) propose(bumpType, suggested string) (string, error) {
+	channel := b.cfg.Version.Channel()
+	if channel == "" && suggested != "" {
+		if channel, _ = b.parser.splitPreRelease(suggested); channel == "" {
+			return "", errors.WrapWithContext(
+				errors.CodeValidateError,
+				errors.ErrInvalidInput,
+				errors.FormatContext(errors.ContextVersionChannel, suggested,
+					strings.Join(b.cfg.Version.Channels(), ", ")),
+			)
+		}
+	}
+
+	proposed, err := ProposeVersion(b.current, bumpType, channel)
+	if err != nil {
+		return "", err
+	}
+
+	if channel != "" {
+		release := releaseOf(proposed)
+		preRelease, err := b.nextPreRelease(&release, channel)
+		if err != nil {
+			return "", err
+		}
+		numbered, err := proposed.SetPrerelease(preRelease)
+		if err != nil {
+			return "", errors.WrapWithContext(
+				errors.CodeValidateError,
+				err,
+				"failed to set pre-release suffix",
+			)
+		}
+		proposed = &numbered
+	}
+
+	if err := b.parser.CheckProgression(proposed); err != nil {
+		return "", err
+	}
+
+	logger.Debug().
+		Str("current_version", b.current.String()).
+		Str("proposed_version", proposed.String()).
+		Str("bump_type", bumpType).
+		Str("channel", channel).
+		Msg("Version change proposed")
+
+	b.proposed = proposed
+	return proposed.String(), nil
+}
+
+// nextPreRelease returns the pre-release of channel numbered one above the highest existing
+// pre-release of the same release and channel, among the tags and the current version
+func (b *Bumper) nextPreRelease(release *semver.Version, channel string) (string, error) {
+	versions, err := versionTags(b.repo)
+	if err != nil {
+		return "", err
+	}
+	versions = append(versions, b.current)
+
+	highest := 0
+	for _, v := range versions {
+		if tagRelease := releaseOf(v); tagRelease.Equal(release) {
+			if tagChannel, number := b.parser.splitPreRelease(v.Prerelease()); tagChannel == channel {
+				highest = max(highest, number)
+			}
+		}
+	}
+
+	return channel + strconv.Itoa(highest+1), nil
+}
+
+// versionTags returns the versions of all tags that are semantic versions
+func versionTags(repo *git.Repository) ([]*semver.Version, error) {
+	refs, err := repo.References()
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeGitError,
+			err,
+			"failed to get repository references",
+		)
+	}
+
+	var versions []*semver.Version
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name().IsTag() {
+			if v, err := semver.NewVersion(strings.TrimPrefix(ref.Name().Short(), "v")); err == nil {
+				versions = append(versions, v)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeGitError,
+			err,
+			"failed to iterate repository references",
+		)
+	}
+
+	return versions, nil
+}
@@ -0,0 +1,183 @@
+package version
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"codeberg.org/mutker/bumpa/internal/config"
+	"codeberg.org/mutker/bumpa/internal/git"
+	"codeberg.org/mutker/bumpa/internal/llm"
+	"codeberg.org/mutker/bumpa/internal/llm/llmtest"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var update = flag.Bool("update", false, "record the fixtures in testdata from scripted responses")
+
+const (
+	greetHello   = "package greet\n\nfunc Hello() string {\n\treturn \"hello\"\n}\n"
+	greetGoodbye = greetHello + "\nfunc Goodbye() string {\n\treturn \"goodbye\"\n}\n"
+	greetWelcome = greetGoodbye + "\nfunc Welcome(name string) string {\n\treturn \"welcome \" + name\n}\n"
+)
+
+// TestAnalyzeVersionChangesReplay proposes the next version for a repository tagged v1.2.0
+// from the recorded fixtures in testdata/analyze. Run with -update to record them again after
+// changing the prompts.
+func TestAnalyzeVersionChangesReplay(t *testing.T) {
+	fixtures, err := filepath.Abs(filepath.Join("testdata", "analyze"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadConfig(t)
+	dir, repo := initRepo(t)
+
+	commit(t, repo, "greet.go", greetHello, "feat: add hello")
+	tagHead(t, repo, "v1.2.0")
+	commit(t, repo, "greet.go", greetGoodbye, "feat: add goodbye")
+	write(t, dir, "greet.go", greetWelcome)
+
+	client := replayClient(t, fixtures, func(server *llmtest.Server) {
+		server.Script("generate_file_summary", toolCall(t, map[string]interface{}{
+			"summary": "Adds a Welcome function that greets by name",
+		}))
+		server.Script("analyze_version_bump", toolCall(t, map[string]interface{}{
+			"bump_type":   "minor",
+			"pre_release": "",
+		}))
+	})
+
+	bumpaRepo, err := git.OpenRepository(dir, cfg.Git)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bumper, err := NewBumper(cfg, client, bumpaRepo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proposed, err := bumper.AnalyzeVersionChanges(context.Background())
+	if err != nil {
+		t.Fatalf("AnalyzeVersionChanges: %v", err)
+	}
+	if want := "1.3.0"; proposed != want {
+		t.Errorf("got %q, want %q", proposed, want)
+	}
+}
+
+// replayClient replays the fixtures in dir. With -update, they are recorded first from a
+// server scripted by script.
+func replayClient(t *testing.T, dir string, script func(*llmtest.Server)) llm.Client {
+	t.Helper()
+
+	if !*update {
+		return llm.NewReplayClient(dir)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	server := llmtest.NewServer()
+	t.Cleanup(server.Close)
+	script(server)
+
+	client, err := llm.New(server.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return llm.NewRecordingClient(client, dir)
+}
+
+// loadConfig loads testdata/bumpa.yaml over the defaults, ignoring any user configuration
+func loadConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	args, err := config.ParseArgs([]string{"--config", filepath.Join("testdata", "bumpa.yaml"), "version"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// initRepo creates an empty repository and makes it the working directory for the rest of
+// the test, where the current version is looked up
+func initRepo(t *testing.T) (string, *gogit.Repository) {
+	t.Helper()
+
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(previous) })
+
+	return dir, repo
+}
+
+// commit writes content to path and commits it with message
+func commit(t *testing.T, repo *gogit.Repository, path, content, message string) {
+	t.Helper()
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, worktree.Filesystem.Root(), path, content)
+	if _, err := worktree.Add(path); err != nil {
+		t.Fatal(err)
+	}
+	_, err = worktree.Commit(message, &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tagHead creates a lightweight tag on the current commit
+func tagHead(t *testing.T, repo *gogit.Repository, name string) {
+	t.Helper()
+
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.CreateTag(name, head.Hash(), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func write(t *testing.T, dir, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// toolCall scripts a tool call answering with args
+func toolCall(t *testing.T, args map[string]interface{}) llmtest.Response {
+	t.Helper()
+
+	response, err := llmtest.ToolCall(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
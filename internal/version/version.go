@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"codeberg.org/mutker/bumpa/internal/config"
@@ -11,25 +12,34 @@ import (
 	"codeberg.org/mutker/bumpa/internal/git"
 	"codeberg.org/mutker/bumpa/internal/llm"
 	"codeberg.org/mutker/bumpa/internal/logger"
+	"codeberg.org/mutker/bumpa/internal/redact"
 	"github.com/Masterminds/semver/v3"
 	"github.com/go-git/go-git/v5/plumbing"
 )
 
 const (
-	filePerms            = 0o600
-	summaryCapacityRatio = 2 // Estimate initial capacity as half of total items
+	filePerms = 0o600
+
+	// Output fields of the version functions
+	summaryField    = "summary"
+	diffField       = "diff"
+	bumpTypeField   = "bump_type"
+	preReleaseField = "pre_release"
+	bumpTypeNoneArg = "none"
 )
 
 // Bumper manages version changes across files and git repository
 type Bumper struct {
-	cfg      *config.Config
-	llm      llm.Client
-	repo     *git.Repository
-	current  *semver.Version
-	proposed *semver.Version
-	files    []config.VersionFile
-	parser   *Parser
-	strategy *Strategy
+	cfg        *config.Config
+	llm        llm.Client
+	repo       *git.Repository
+	current    *semver.Version
+	proposed   *semver.Version
+	files      []config.VersionFile
+	parser     *Parser
+	strategy   *Strategy
+	summarizer *llm.Summarizer
+	redactor   *redact.Redactor
 }
 
 // Strategy defines keywords for version change detection
@@ -63,6 +73,11 @@ func NewBumper(cfg *config.Config, llmClient llm.Client, repo *git.Repository) (
 		return nil, err
 	}
 
+	redactor, err := redact.New(cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+
 	strategy := &Strategy{
 		breakingKeywords: []string{
 			"!:",               // Conventional Commits breaking change indicator
@@ -78,13 +93,15 @@ func NewBumper(cfg *config.Config, llmClient llm.Client, repo *git.Repository) (
 	}
 
 	return &Bumper{
-		cfg:      cfg,
-		llm:      llmClient,
-		repo:     repo,
-		current:  current,
-		files:    cfg.Version.Files,
-		parser:   New(current, strategy.breakingKeywords, strategy.featureKeywords),
-		strategy: strategy,
+		cfg:        cfg,
+		llm:        llmClient,
+		repo:       repo,
+		current:    current,
+		files:      cfg.Version.Files,
+		parser:     New(current, cfg.Version.Channels(), strategy.breakingKeywords, strategy.featureKeywords),
+		strategy:   strategy,
+		summarizer: llm.NewSummarizer(llmClient, cfg),
+		redactor:   redactor,
 	}, nil
 }
 
@@ -110,8 +127,8 @@ func (b *Bumper) GetWorkflowState() (*WorkflowState, error) {
 		HasCommit:   status.HasCommit,
 		NeedsTag:    b.cfg.Version.Git.Tag && !status.HasTag,
 		NeedsCommit: len(b.files) > 0 && b.cfg.Version.Git.Commit && !status.HasCommit,
-		SignTag:     b.cfg.Version.Git.Signage,
-		SignCommit:  b.cfg.Version.Git.Signage,
+		SignTag:     b.cfg.Version.Git.SignTag,
+		SignCommit:  b.cfg.Version.Git.SignCommit,
 	}, nil
 }
 
@@ -131,12 +148,22 @@ func (b *Bumper) GetFilesToUpdate() []string {
 
 // AnalyzeVersionChanges analyzes changes and suggests version bump
 func (b *Bumper) AnalyzeVersionChanges(ctx context.Context) (string, error) {
+	// An explicit version --bump needs no analysis
+	if b.cfg.Version.Bump != "" {
+		return b.propose(b.cfg.Version.Bump, "")
+	}
+
 	// If this is the initial version, propose 0.1.0 without any further analysis
 	if b.current.String() == "0.1.0" {
 		b.proposed = b.current
 		return b.current.String(), nil
 	}
 
+	// Offline the commit history is all there is to go on, working tree changes need an LLM
+	if b.cfg.Offline {
+		return b.analyzeOffline()
+	}
+
 	// Check if there are any changes to analyze
 	status, err := b.repo.Status()
 	if err != nil {
@@ -188,14 +215,7 @@ func (b *Bumper) AnalyzeVersionChanges(ctx context.Context) (string, error) {
 		return "", err
 	}
 
-	// Create proposed version
-	proposed, err := ProposeVersion(b.current, bumpType, preRelease)
-	if err != nil {
-		return "", err
-	}
-
-	b.proposed = proposed
-	return proposed.String(), nil
+	return b.propose(bumpType, preRelease)
 }
 
 // GetProposedVersion returns the currently proposed version
@@ -391,21 +411,29 @@ func (b *Bumper) analyzeFiles(ctx context.Context) ([]string, error) {
 		)
 	}
 
-	// Pre-allocate with a reasonable initial capacity
-	fileSummaries := make([]string, 0, len(status)/summaryCapacityRatio)
-	for path, fileStatus := range status {
-		if b.repo.ShouldIgnoreFile(path, b.cfg.Git.Ignore, b.cfg.Git.IncludeGitignore) {
-			continue
-		}
+	tool := config.FindFunction(b.cfg.Functions, "generate_file_summary")
+	if tool == nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeConfigError,
+			errors.ErrInvalidInput,
+			"generate_file_summary tool configuration not found",
+		)
+	}
 
-		summary, err := b.analyzeFile(ctx, path, fileStatus.Staging)
-		if err != nil {
-			return nil, err
+	paths := make([]string, 0, len(status))
+	changed := make([]string, 0, len(status))
+	for path := range status {
+		changed = append(changed, path)
+		if !b.repo.ShouldIgnoreFile(path, b.cfg.Git.Ignore, b.cfg.Git.IncludeGitignore) {
+			paths = append(paths, path)
 		}
-		fileSummaries = append(fileSummaries, summary)
 	}
+	sort.Strings(paths)
 
-	if len(fileSummaries) == 0 {
+	// Summaries may only mention files that are part of the change
+	ctx = llm.WithOutputCheck(ctx, llm.ReferenceCheck(changed))
+
+	if len(paths) == 0 {
 		return nil, errors.WrapWithContext(
 			errors.CodeNoChanges,
 			errors.ErrInvalidInput,
@@ -413,37 +441,73 @@ func (b *Bumper) analyzeFiles(ctx context.Context) ([]string, error) {
 		)
 	}
 
-	return fileSummaries, nil
+	// Read diffs sequentially, the worktree isn't safe for concurrent use
+	inputs := make([]map[string]interface{}, 0, len(paths))
+	for _, path := range paths {
+		input, err := b.prepareFileInput(path, status.File(path).Staging)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+
+	summaries, err := llm.RunConcurrent(ctx, b.cfg.LLM.Concurrency, inputs,
+		func(ctx context.Context, input map[string]interface{}) (string, error) {
+			return b.analyzeFile(ctx, tool, input)
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSummaries := make(map[string]string, len(paths))
+	for i, path := range paths {
+		fileSummaries[path] = summaries[i]
+	}
+
+	// Many changed files are folded into directory summaries to keep the bump prompt small
+	fileSummaries, err = b.summarizer.ReduceDirectories(ctx, fileSummaries)
+	if err != nil {
+		return nil, errors.WrapWithContext(
+			errors.CodeLLMError,
+			err,
+			"failed to reduce file summaries",
+		)
+	}
+
+	keys := make([]string, 0, len(fileSummaries))
+	for key := range fileSummaries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+": "+fileSummaries[key])
+	}
+	return lines, nil
 }
 
-// analyzeFile generates a summary of changes for a single file
-func (b *Bumper) analyzeFile(ctx context.Context, path string, status git.StatusCode) (string, error) {
-	diff, err := b.repo.GetFileDiff(path)
+// prepareFileInput builds the generate_file_summary input for a single file
+func (b *Bumper) prepareFileInput(path string, status git.StatusCode) (map[string]interface{}, error) {
+	diff, err := b.redactor.Diff(path, b.repo.GetFileDiff)
 	if err != nil {
-		return "", errors.WrapWithContext(
+		return nil, errors.WrapWithContext(
 			errors.CodeGitError,
 			err,
 			"failed to get diff for file: "+path,
 		)
 	}
 
-	tool := config.FindFunction(b.cfg.Functions, "generate_file_summary")
-	if tool == nil {
-		return "", errors.WrapWithContext(
-			errors.CodeConfigError,
-			errors.ErrInvalidInput,
-			"generate_file_summary tool configuration not found",
-		)
-	}
+	return llm.FileSummaryInput(path, git.GetFileStatus(status), diff), nil
+}
 
-	input := map[string]interface{}{
-		"file":                  path,
-		"status":                git.GetFileStatus(status),
-		"diff":                  diff,
-		"hasSignificantChanges": true, // Always consider changes significant for version analysis
-	}
+// analyzeFile generates a summary of changes for a single file
+func (b *Bumper) analyzeFile(ctx context.Context, tool *config.LLMFunction, input map[string]interface{}) (string, error) {
+	path, _ := input["file"].(string)
 
-	summary, err := llm.CallFunction(ctx, b.llm, tool, input)
+	diff, _ := input[diffField].(string)
+	summary, err := b.summarizer.SummarizeFile(ctx, tool, input, diffField, git.SplitHunks(diff), summaryField)
 	if err != nil {
 		return "", errors.WrapWithContext(
 			errors.CodeLLMError,
@@ -452,7 +516,7 @@ func (b *Bumper) analyzeFile(ctx context.Context, path string, status git.Status
 		)
 	}
 
-	return path + ": " + summary, nil
+	return summary, nil
 }
 
 // getVersionSuggestion requests version change suggestion from LLM
@@ -467,14 +531,15 @@ func (b *Bumper) getVersionSuggestion(ctx context.Context, fileSummaries []strin
 	}
 
 	input := map[string]interface{}{
-		"current_version":   b.current.String(),
-		"file_changes":      strings.Join(fileSummaries, "\n"),
-		"commit_history":    commits,
-		"breaking_keywords": b.strategy.breakingKeywords,
-		"feature_keywords":  b.strategy.featureKeywords,
+		"current_version":      b.current.String(),
+		"file_changes":         strings.Join(fileSummaries, "\n"),
+		"commit_history":       commits,
+		"breaking_keywords":    b.strategy.breakingKeywords,
+		"feature_keywords":     b.strategy.featureKeywords,
+		"pre_release_channels": b.cfg.Version.Channels(),
 	}
 
-	suggestion, err := llm.CallFunction(ctx, b.llm, function, input)
+	result, err := llm.CallFunction(ctx, b.llm, function, input, b.cfg.LLM.MaxRetries)
 	if err != nil {
 		return "", errors.WrapWithContext(
 			errors.CodeLLMError,
@@ -483,32 +548,60 @@ func (b *Bumper) getVersionSuggestion(ctx context.Context, fileSummaries []strin
 		)
 	}
 
-	return strings.TrimSpace(suggestion), nil
+	// Convert the structured result into the "bump:prerelease" suggestion format
+	bumpType := result.String(bumpTypeField)
+	if bumpType == bumpTypeNoneArg {
+		bumpType = bumpTypeNone
+	}
+	preRelease := result.String(preReleaseField)
+	if preRelease == "stable" {
+		preRelease = ""
+	}
+
+	return bumpType + ":" + preRelease, nil
 }
 
 // getChangesSinceLastVersion retrieves commit history since the last version tag
 func (b *Bumper) getChangesSinceLastVersion() (string, error) {
-	// Get the last version tag
-	lastTag, err := b.findLastVersionTag()
+	messages, err := b.commitMessagesSinceLastVersion()
 	if err != nil {
 		return "", err
 	}
+	return strings.Join(messages, "\n"), nil
+}
+
+// commitMessagesSinceLastVersion returns the messages of all commits after the last version
+// tag, or of the whole history if there is none
+func (b *Bumper) commitMessagesSinceLastVersion() ([]string, error) {
+	lastTag, err := b.findLastVersionTag()
+	if err != nil {
+		return nil, err
+	}
 
-	// If no previous version tag exists, get all changes
 	if lastTag == "" {
-		messages, err := b.repo.GetAllCommitMessages()
-		if err != nil {
-			return "", err
-		}
-		return strings.Join(messages, "\n"), nil
+		return b.repo.GetAllCommitMessages()
 	}
+	return b.repo.GetChangesSinceTag(lastTag)
+}
 
-	// Get changes since the last tag
-	messages, err := b.repo.GetChangesSinceTag(lastTag)
+// analyzeOffline proposes a version from the Conventional Commits since the last version tag,
+// without summarizing changes through an LLM
+func (b *Bumper) analyzeOffline() (string, error) {
+	messages, err := b.commitMessagesSinceLastVersion()
 	if err != nil {
 		return "", err
 	}
-	return strings.Join(messages, "\n"), nil
+
+	bumpType := b.parser.BumpFromCommits(messages)
+
+	logger.Info().
+		Int("commits", len(messages)).
+		Str("bump_type", bumpType).
+		Msg("Determined version bump from commit history")
+
+	// Offline there is nothing to judge stability by, so a pre-release stays on its channel
+	channel, _ := b.parser.splitPreRelease(b.current.Prerelease())
+	return b.propose(bumpType, channel)
 }
 
 func (b *Bumper) CheckVersionObjects(version string) (VersionStatus, error) {